@@ -3,8 +3,10 @@ package main
 import (
 	"github.com/gotomicro/ego"
 	"github.com/gotomicro/ego/core/elog"
+	"github.com/gotomicro/ego/task/ecron"
 
 	"goprobe/pkg/invoker"
+	"goprobe/pkg/pprof"
 	"goprobe/pkg/server"
 )
 
@@ -15,6 +17,7 @@ func main() {
 			//egovernor.Load("server.governor").Build(),
 			server.ServeHTTP(),
 		).
+		Cron(ecron.Load("cron.gc").Build(ecron.WithJob(pprof.GCJob))).
 		Run()
 	if err != nil {
 		elog.Panic("start up error: " + err.Error())
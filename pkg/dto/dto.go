@@ -12,24 +12,201 @@ type (
 		Seconds     int    `form:"seconds" json:"seconds"`
 		Type        int    `form:"type"`
 		Token       string `form:"token"`
+		Operator    string `form:"operator" json:"operator"`       // 操作人备注, 审计记录的操作人是 token 对应的身份, 为空时取 header X-Goprobe-Operator
+		TriggeredBy string `form:"triggeredBy" json:"triggeredBy"` // manual | scheduled | threshold, 默认 manual
+		Labels      string `form:"labels" json:"labels"`           // k1=v1,k2=v2, 如 release=v1.42,env=canary
+		SampleIndex string `form:"sampleIndex" json:"sampleIndex"` // 返回的图链接使用的 sample 类型, 如 alloc_space
 
 		UniqueKey string `form:"-" json:"-"`
 	}
 
 	ReqPprofGraph struct {
-		SvgType string `form:"svgType"` // flame | profile
+		SvgType string `form:"svgType"` // flame | profile | html (可交互的火焰图页面)
 		GoType  string `form:"goType"`  // block | goroutine | heap | profile
 		Url     string `form:"url"`
+		// SampleIndex 选择 sample 类型, 如 inuse_space, inuse_objects, alloc_space, alloc_objects, contentions, delay, 为空时使用默认类型
+		SampleIndex string `form:"sampleIndex"`
+		// 以下为 go tool pprof 同名过滤条件, 均为正则
+		Focus    string `form:"focus"`    // 只保留调用栈包含匹配函数的 sample
+		Ignore   string `form:"ignore"`   // 丢弃调用栈包含匹配函数的 sample
+		Hide     string `form:"hide"`     // 从调用栈中隐藏匹配的函数
+		Show     string `form:"show"`     // 调用栈中只显示匹配的函数
+		TagFocus string `form:"tagfocus"` // 按 label 过滤, key=regexp 或匹配任意 label 值
+		Prune    string `form:"prune"`    // 去掉最底层匹配函数之下的调用, 同 -prune_from
+		// 输出格式 svg | png | pdf, 默认 svg, png/pdf 由 svg 转换并缓存, html 不支持转换
+		Format string `form:"format"`
+		Width  int    `form:"width"` // png/pdf 宽度, 单位像素, 按比例缩放, 为 0 时保持原尺寸, 可选 0/800/1200/1600/2400/3200
+		Dpi    int    `form:"dpi"`   // png/pdf 分辨率, 为 0 时为 96, 可选 0/72/96/150/300, 宽度×dpi 不超过 1600×300
 	}
 
 	ReqGetPprofList struct {
-		ClusterName string `form:"clusterName" binding:"required"`
+		ClusterName string `form:"clusterName"` // 为空时列出不属于集群的 addr/upload capture
 		Namespace   string `form:"namespace" binding:"required"`
+		PodName     string `form:"podName"` // pod 名或 addr 前缀
+		Status      string `form:"status"`
+		Labels      string `form:"labels"` // k1=v1,k2=v2, 需全部匹配
 	}
 
 	RespGetPprofListItem struct {
-		Url     string `json:"url"`
-		PodName string `json:"podName"`
-		Ctime   int64  `json:"ctime"`
+		Url      string            `json:"url"`
+		PodName  string            `json:"podName"`
+		Addr     string            `json:"addr"`
+		Ctime    int64             `json:"ctime"`
+		Seconds  int               `json:"seconds"`
+		Operator string            `json:"operator"`
+		Kinds    []string          `json:"kinds"`
+		Status   string            `json:"status"`
+		Error    string            `json:"error,omitempty"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Note     string            `json:"note,omitempty"`
+	}
+
+	// ReqListHistory 分页查询 capture 历史, 过滤条件均可选
+	ReqListHistory struct {
+		ClusterName string `form:"clusterName"`
+		Namespace   string `form:"namespace"`
+		PodName     string `form:"podName"`  // pod 名前缀
+		Workload    string `form:"workload"` // 工作负载名, 或 Kind/Name, 如 Deployment/api
+		Addr        string `form:"addr"`     // addr 前缀
+		Kind        string `form:"kind"`     // profile 类型, 如 heap
+		Start       int64  `form:"start"`    // unix 秒, 含
+		End         int64  `form:"end"`      // unix 秒, 不含
+		TriggeredBy string `form:"triggeredBy"`
+		Status      string `form:"status"`
+		Labels      string `form:"labels"` // k1=v1,k2=v2, 需全部匹配
+		Sort        string `form:"sort"`   // desc | asc, 按采集时间, 默认 desc
+		Page        int    `form:"page"`   // 从 1 开始
+		PageSize    int    `form:"pageSize"`
+	}
+
+	ReqGetCapture struct {
+		Url string `form:"url" binding:"required"`
+	}
+
+	ReqDeleteCapture struct {
+		Url      string `form:"url" json:"url" binding:"required"`
+		Operator string `form:"operator" json:"operator"`
+	}
+
+	// ReqDeleteCaptures 按条件批量删除, 至少需要 clusterName/namespace/podName/before 之一
+	ReqDeleteCaptures struct {
+		ClusterName   string `form:"clusterName" json:"clusterName"`
+		Namespace     string `form:"namespace" json:"namespace"`
+		PodName       string `form:"podName" json:"podName"` // pod 名或 addr 前缀
+		Status        string `form:"status" json:"status"`
+		Before        int64  `form:"before" json:"before"` // unix 秒, 删除此时间之前的
+		After         int64  `form:"after" json:"after"`
+		IncludePinned bool   `form:"includePinned" json:"includePinned"`
+		Operator      string `form:"operator" json:"operator"`
+	}
+
+	ReqListAudit struct {
+		Date string `form:"date" binding:"required"` // 2006-01-02
+	}
+
+	ReqDownloadRaw struct {
+		Url    string `form:"url" binding:"required"`
+		GoType string `form:"goType" binding:"required"` // block | goroutine | heap | profile
+	}
+
+	ReqGetTop struct {
+		Url         string `form:"url" binding:"required"`
+		GoType      string `form:"goType" binding:"required"` // block | goroutine | heap | profile
+		SampleIndex string `form:"sampleIndex"`               // 为空时使用 profile 的默认类型
+		Granularity string `form:"granularity"`               // functions | lines | packages, 默认 functions
+		N           int    `form:"n"`                         // 默认 20
+	}
+
+	ReqExport struct {
+		Url         string `form:"url" binding:"required"`
+		GoType      string `form:"goType" binding:"required"`
+		Format      string `form:"format" binding:"required"` // speedscope | collapsed | chrome
+		SampleIndex string `form:"sampleIndex"`               // 为空时使用 profile 的默认类型
+	}
+
+	// ReqGetSource 按源码行 (list) 或指令 (disasm) 标注匹配 func 正则的函数, disasm 需要先上传二进制
+	ReqGetSource struct {
+		Url         string `form:"url" binding:"required"`
+		GoType      string `form:"goType" binding:"required"`
+		Func        string `form:"func" binding:"required"` // 函数名正则
+		View        string `form:"view"`                    // list | disasm, 默认 list
+		SampleIndex string `form:"sampleIndex"`             // 为空时使用 profile 的默认类型
+	}
+
+	// ReqAttachBinary multipart 上传目标进程的可执行文件, 文件字段名为 binary
+	ReqAttachBinary struct {
+		Url      string `form:"url" binding:"required"`
+		Operator string `form:"operator"`
+	}
+
+	// ReqSymbolize 用目标进程的二进制重新符号化 capture 的 profile 并重新渲染, kinds 为空时处理全部
+	ReqSymbolize struct {
+		Url      string   `json:"url" binding:"required"`
+		Kinds    []string `json:"kinds"`
+		Operator string   `json:"operator"`
+	}
+
+	// ReqRerender 删除缓存的图, 之后在首次请求时重新渲染, 如升级渲染工具后; url 为空且 all 为 true 时处理全部 capture
+	ReqRerender struct {
+		Url      string `json:"url"`
+		All      bool   `json:"all"`
+		Eager    bool   `json:"eager"` // 立即重新渲染默认的火焰图和 Profile 图
+		Operator string `json:"operator"`
+	}
+
+	ReqDownloadBundle struct {
+		Url    string `form:"url" binding:"required"`
+		Format string `form:"format"` // zip | tar.gz, 默认 zip
+	}
+
+	// ReqUploadProfile multipart 上传, 每个文件的表单字段名即 profile 类型, 如 heap=@heap.pb.gz
+	ReqUploadProfile struct {
+		ClusterName string `form:"clusterName"`
+		Name        string `form:"name"`   // 来源名称, 如机器名或 benchmark 名
+		Labels      string `form:"labels"` // k1=v1,k2=v2
+		Operator    string `form:"operator"`
+	}
+
+	// ReqUpdateCapture 修改 capture 的标签和备注, 字段为空时不修改, labels 整体替换
+	ReqUpdateCapture struct {
+		Url      string            `json:"url" binding:"required"`
+		Labels   map[string]string `json:"labels"`
+		Note     *string           `json:"note"`
+		Operator string            `json:"operator"`
+	}
+
+	ReqPinCapture struct {
+		Url    string `form:"url" json:"url" binding:"required"`
+		Pinned bool   `form:"pinned" json:"pinned"`
+	}
+
+	ReqListPods struct {
+		ClusterName   string `form:"clusterName" binding:"required"`
+		Namespace     string `form:"namespace"`
+		LabelSelector string `form:"labelSelector"`
+	}
+
+	RespPodItem struct {
+		Name         string `json:"name"`
+		Namespace    string `json:"namespace"`
+		PodIP        string `json:"podIP"`
+		NodeName     string `json:"nodeName"`
+		Phase        string `json:"phase"`
+		WorkloadKind string `json:"workloadKind"`
+		WorkloadName string `json:"workloadName"`
+	}
+
+	ReqCluster struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		ApiServer   string  `json:"apiServer"`
+		KubeConfig  string  `json:"kubeConfig"` // kubeconfig 文件内容, JSON 或 YAML
+		Context     string  `json:"context"`
+		InCluster   bool    `json:"inCluster"`
+		Token       string  `json:"token"`
+		CAData      string  `json:"caData"`
+		Proxy       string  `json:"proxy"`
+		QPS         float32 `json:"qps"`
+		Burst       int     `json:"burst"`
 	}
 )
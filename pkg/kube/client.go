@@ -1,7 +1,6 @@
 package kube
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -15,16 +14,13 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
-	clientcmdlatest "k8s.io/client-go/tools/clientcmd/api/latest"
-	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
 )
 
 const (
-	// High enough QPS to fit all expected use cases.
-	defaultQPS = 1e6
-	// High enough Burst to fit all expected use cases.
-	defaultBurst = 1e6
+	// Client side rate limit, can be overridden by kube.qps/kube.burst or per cluster.
+	// Most lookups are served by the informer cache so these are rarely reached.
+	defaultQPS   = 50
+	defaultBurst = 100
 )
 
 var (
@@ -33,58 +29,108 @@ var (
 
 type ClusterManager struct {
 	Cluster *Cluster
-	Client  *kubernetes.Clientset
+	Client  kubernetes.Interface
 	Config  *rest.Config
+
+	cache       *clusterCache
+	fingerprint string
+
+	mu      sync.Mutex
+	refs    int
+	retired bool
 }
 
 func InitApiServerClient() {
-	newClusters, err := GetAllClusters()
+	ReloadClusters()
+	watchClusterConfig()
+	startHealthChecks()
+	elog.Info("cluster finished! ")
+}
+
+// newClusterManager builds the client of cluster and starts its informer cache.
+func newClusterManager(cluster *Cluster, fingerprint string) (*ClusterManager, error) {
+	kubeConfigData, err := cluster.kubeConfigData()
 	if err != nil {
-		elog.Error("get all normal(status==0) clusters error while building apiServer client.", zap.Error(err))
-		return
+		return nil, err
+	}
+	clientSet, config, err := buildClient(cluster.ApiServer, kubeConfigData, cluster.buildOptions()...)
+	if err != nil {
+		return nil, err
 	}
 
-	// build new clientManager
-	for i := 0; i < len(newClusters); i++ {
-		cluster := newClusters[i]
-		// deal with invalid cluster
-		if cluster.ApiServer == "" {
-			elog.Warn("cluster's apiServer is null:%s", zap.String("clusterName", cluster.Name))
-			continue
-		}
-		var buildOptions []ClientBuildOption
-		if cluster.Proxy != "" {
-			buildOptions = append(buildOptions, WithProxy(cluster.Proxy))
-		}
-		clientSet, config, err := buildClient(cluster.ApiServer, cluster.KubeConfig, buildOptions...)
-		if err != nil {
-			elog.Warn(fmt.Sprintf("build cluster (%s)'s client error.", cluster.Name), zap.Error(err))
-			continue
-		}
+	clusterManager := &ClusterManager{
+		Cluster:     cluster,
+		Client:      clientSet,
+		Config:      config,
+		fingerprint: fingerprint,
+	}
+	clusterManager.cache = newClusterCache(clusterManager)
+	clusterManager.cache.start(cluster.Name)
+	return clusterManager, nil
+}
 
-		clusterManager := &ClusterManager{
-			Cluster: cluster,
-			Client:  clientSet,
-			Config:  config,
+// GetClusterManager returns the manager of cluster name, the caller must Release it when done
+// so a reload never tears down a manager that is still serving a request.
+func GetClusterManager(name string) (*ClusterManager, error) {
+	for {
+		managerInterface, exist := clusterManagerSets.Load(name)
+		if !exist {
+			// the cluster is configured but its client could not be built
+			if v, ok := clusterStatusSets.Load(name); ok {
+				return nil, fmt.Errorf("cluster %s is unavailable: %s", name, v.(*ClusterStatus).LastError)
+			}
+			return nil, fmt.Errorf("not exist name: " + name)
+		}
+		manager := managerInterface.(*ClusterManager)
+		// a retired manager has already been replaced or removed, load again
+		if manager.acquire() {
+			return manager, nil
 		}
-		clusterManagerSets.Store(cluster.Name, clusterManager)
 	}
-	elog.Info("cluster finished! ")
+}
 
+func (m *ClusterManager) acquire() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.retired {
+		return false
+	}
+	m.refs++
+	return true
 }
 
-func GetClusterManager(name string) (*ClusterManager, error) {
-	managerInterface, exist := clusterManagerSets.Load(name)
-	if !exist {
-		return nil, fmt.Errorf("not exist name: " + name)
+// Release gives back a manager obtained by GetClusterManager.
+func (m *ClusterManager) Release() {
+	m.mu.Lock()
+	m.refs--
+	stop := m.retired && m.refs == 0
+	m.mu.Unlock()
+	if stop {
+		m.cache.stop()
 	}
-	manager := managerInterface.(*ClusterManager)
+}
 
-	return manager, nil
+// retire stops the manager once the last in-flight request releases it.
+func (m *ClusterManager) retire() {
+	m.mu.Lock()
+	m.retired = true
+	stop := m.refs == 0
+	m.mu.Unlock()
+	if stop {
+		m.cache.stop()
+	}
 }
 
 type kubeClientOption struct {
 	proxyAddr string
+	qps       float32
+	burst     int
+	context   string
+	inCluster bool
+	token     string
+	tokenFile string
+	caFile    string
+	caData    string
 }
 type ClientBuildOption func(*kubeClientOption)
 
@@ -94,37 +140,79 @@ func WithProxy(proxyAddr string) func(option *kubeClientOption) {
 	}
 }
 
-func buildClient(apiServerAddr string, kubeconfig string, options ...ClientBuildOption) (*kubernetes.Clientset, *rest.Config, error) {
-	o := kubeClientOption{}
-	for _, opt := range options {
-		opt(&o)
+// WithRateLimit sets the client side rate limit, zero values fall back to kube.qps/kube.burst.
+func WithRateLimit(qps float32, burst int) func(option *kubeClientOption) {
+	return func(option *kubeClientOption) {
+		if qps > 0 {
+			option.qps = qps
+		}
+		if burst > 0 {
+			option.burst = burst
+		}
 	}
-	configV1 := clientcmdapiv1.Config{}
-	// 读取二进制内容
-	rawData, err := ioutil.ReadFile(kubeconfig)
-	if err != nil {
-		return nil, nil, fmt.Errorf("buildClient 读取二进制失败, %w", err)
+}
+
+// WithContext selects a kubeconfig context instead of current-context.
+func WithContext(context string) func(option *kubeClientOption) {
+	return func(option *kubeClientOption) {
+		option.context = context
 	}
-	err = json.Unmarshal(rawData, &configV1)
-	if err != nil {
-		elog.Error("json unmarshal kubeconfig error.", zap.Error(err))
-		return nil, nil, err
+}
+
+// WithInCluster uses the service account goprobe runs with instead of a kubeconfig.
+func WithInCluster() func(option *kubeClientOption) {
+	return func(option *kubeClientOption) {
+		option.inCluster = true
 	}
-	configObject, err := clientcmdlatest.Scheme.ConvertToVersion(&configV1, clientcmdapi.SchemeGroupVersion)
-	configInternal := configObject.(*clientcmdapi.Config)
+}
 
-	clientConfig, err := clientcmd.NewDefaultClientConfig(*configInternal, &clientcmd.ConfigOverrides{
-		ClusterDefaults: clientcmdapi.Cluster{Server: apiServerAddr}, // InsecureSkipTLSVerify: true,
+// WithBearerToken authenticates with a static token or a token file that is re-read on rotation.
+func WithBearerToken(token, tokenFile string) func(option *kubeClientOption) {
+	return func(option *kubeClientOption) {
+		option.token = token
+		option.tokenFile = tokenFile
+	}
+}
 
-	}).ClientConfig()
+// WithCA sets the CA used to verify apiServer when no kubeconfig provides one.
+func WithCA(caFile, caData string) func(option *kubeClientOption) {
+	return func(option *kubeClientOption) {
+		option.caFile = caFile
+		option.caData = caData
+	}
+}
 
+// buildClient builds a client from, in order of precedence, the in-cluster config,
+// a JSON or YAML kubeconfig, or apiServerAddr alone with token auth.
+// A non empty apiServerAddr overrides the server of the kubeconfig.
+func buildClient(apiServerAddr string, rawData []byte, options ...ClientBuildOption) (*kubernetes.Clientset, *rest.Config, error) {
+	o := kubeClientOption{
+		qps:   float32(econf.GetFloat64("kube.qps")),
+		burst: econf.GetInt("kube.burst"),
+	}
+	for _, opt := range options {
+		opt(&o)
+	}
+	clientConfig, err := buildRestConfig(apiServerAddr, rawData, o)
 	if err != nil {
 		elog.Error("build client config error. ", zap.Error(err))
 		return nil, nil, err
 	}
+	if o.token != "" {
+		clientConfig.BearerToken = o.token
+	}
+	if o.tokenFile != "" {
+		clientConfig.BearerTokenFile = o.tokenFile
+	}
 
 	clientConfig.QPS = defaultQPS
 	clientConfig.Burst = defaultBurst
+	if o.qps > 0 {
+		clientConfig.QPS = o.qps
+	}
+	if o.burst > 0 {
+		clientConfig.Burst = o.burst
+	}
 
 	if o.proxyAddr != "" {
 		clientConfig.Proxy = func(request *http.Request) (*url.URL, error) {
@@ -141,15 +229,134 @@ func buildClient(apiServerAddr string, kubeconfig string, options ...ClientBuild
 	return clientSet, clientConfig, nil
 }
 
+func buildRestConfig(apiServerAddr string, rawData []byte, o kubeClientOption) (*rest.Config, error) {
+	if o.inCluster {
+		clientConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("load in-cluster config error: %w", err)
+		}
+		if apiServerAddr != "" {
+			clientConfig.Host = apiServerAddr
+		}
+		return clientConfig, nil
+	}
+
+	if len(rawData) == 0 {
+		if apiServerAddr == "" {
+			return nil, fmt.Errorf("apiServer is required when neither kubeconfig nor inCluster is set")
+		}
+		return &rest.Config{
+			Host: apiServerAddr,
+			TLSClientConfig: rest.TLSClientConfig{
+				CAFile: o.caFile,
+				CAData: []byte(o.caData),
+			},
+		}, nil
+	}
+
+	// clientcmd.Load accepts both YAML and JSON kubeconfigs
+	config, err := clientcmd.Load(rawData)
+	if err != nil {
+		return nil, fmt.Errorf("load kubeconfig error: %w", err)
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	if apiServerAddr != "" {
+		overrides.ClusterInfo.Server = apiServerAddr
+	}
+	return clientcmd.NewDefaultClientConfig(*config, overrides).ClientConfig()
+}
+
+const (
+	// ClusterSourceConfig clusters come from [[cluster]] and can only be changed by editing the config.
+	ClusterSourceConfig = "config"
+	// ClusterSourceAPI clusters are registered at runtime and persisted in the cluster store.
+	ClusterSourceAPI = "api"
+)
+
 type Cluster struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	ApiServer   string `json:"apiServer"`
-	KubeConfig  string `json:"kubeConfig"`
-	Proxy       string `json:"proxy"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	ApiServer   string  `json:"apiServer"`
+	KubeConfig  string  `json:"kubeConfig"`
+	Proxy       string  `json:"proxy"`
+	QPS         float32 `json:"qps"`
+	Burst       int     `json:"burst"`
+	// KubeConfigContent is the inline kubeconfig of clusters registered through the API.
+	KubeConfigContent string `json:"kubeConfigContent,omitempty"`
+	// Context selects a kubeconfig context, empty means current-context.
+	Context string `json:"context"`
+	// InCluster uses the service account of goprobe's own pod.
+	InCluster bool   `json:"inCluster"`
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"tokenFile"`
+	CAFile    string `json:"caFile"`
+	CAData    string `json:"caData"`
+	Source    string `json:"source"`
+}
+
+func (c *Cluster) buildOptions() []ClientBuildOption {
+	buildOptions := []ClientBuildOption{
+		WithRateLimit(c.QPS, c.Burst),
+		WithContext(c.Context),
+		WithBearerToken(c.Token, c.TokenFile),
+		WithCA(c.CAFile, c.CAData),
+	}
+	if c.Proxy != "" {
+		buildOptions = append(buildOptions, WithProxy(c.Proxy))
+	}
+	if c.InCluster {
+		buildOptions = append(buildOptions, WithInCluster())
+	}
+	return buildOptions
+}
+
+// validate checks that cluster has a way to reach and authenticate to apiServer.
+func (c *Cluster) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("cluster name cannot be empty")
+	}
+	if c.InCluster || c.KubeConfig != "" || c.KubeConfigContent != "" {
+		return nil
+	}
+	if c.ApiServer == "" {
+		return fmt.Errorf("cluster %s needs one of inCluster, kubeConfig or apiServer", c.Name)
+	}
+	if c.Token == "" && c.TokenFile == "" {
+		return fmt.Errorf("cluster %s needs token or tokenFile when no kubeconfig is set", c.Name)
+	}
+	return nil
 }
 
+// kubeConfigData returns the inline kubeconfig if present, otherwise the content of the kubeconfig file.
+// Clusters without a kubeconfig return nil.
+func (c *Cluster) kubeConfigData() ([]byte, error) {
+	if c.KubeConfigContent != "" {
+		return []byte(c.KubeConfigContent), nil
+	}
+	if c.KubeConfig == "" {
+		return nil, nil
+	}
+	// 读取二进制内容
+	rawData, err := ioutil.ReadFile(c.KubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("buildClient 读取二进制失败, %w", err)
+	}
+	return rawData, nil
+}
+
+// GetAllClusters returns the clusters of the config followed by the ones registered through the API.
 func GetAllClusters() (result []*Cluster, err error) {
 	err = econf.UnmarshalKey("cluster", &result)
+	if err != nil {
+		return
+	}
+	for _, cluster := range result {
+		cluster.Source = ClusterSourceConfig
+	}
+	stored, err := defaultClusterStore().list()
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, stored...)
 	return
 }
@@ -20,3 +20,55 @@ func TestGetAllClusters(t *testing.T) {
 		fmt.Printf("gotResult--------------->"+"%+v\n", value)
 	}
 }
+
+const yamlKubeConfig = `
+apiVersion: v1
+kind: Config
+clusters:
+- name: a
+  cluster:
+    server: https://a.example.com:6443
+- name: b
+  cluster:
+    server: https://b.example.com:6443
+users:
+- name: u
+  user:
+    token: secret
+contexts:
+- name: a
+  context: {cluster: a, user: u}
+- name: b
+  context: {cluster: b, user: u}
+current-context: a
+`
+
+func TestBuildRestConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		apiServer string
+		data      []byte
+		option    kubeClientOption
+		wantHost  string
+		wantErr   bool
+	}{
+		{name: "yaml current-context", data: []byte(yamlKubeConfig), wantHost: "https://a.example.com:6443"},
+		{name: "yaml selected context", data: []byte(yamlKubeConfig), option: kubeClientOption{context: "b"}, wantHost: "https://b.example.com:6443"},
+		{name: "apiServer overrides kubeconfig", apiServer: "https://c.example.com", data: []byte(yamlKubeConfig), wantHost: "https://c.example.com"},
+		{name: "missing context", data: []byte(yamlKubeConfig), option: kubeClientOption{context: "x"}, wantErr: true},
+		{name: "token only", apiServer: "https://d.example.com", wantHost: "https://d.example.com"},
+		{name: "nothing", wantErr: true},
+		{name: "invalid kubeconfig", data: []byte("{"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildRestConfig(tt.apiServer, tt.data, tt.option)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildRestConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && config.Host != tt.wantHost {
+				t.Errorf("buildRestConfig() host = %s, want %s", config.Host, tt.wantHost)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gotomicro/ego/core/econf"
+	"github.com/gotomicro/ego/core/elog"
+	"go.uber.org/zap"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	healthCheckTimeout         = 10 * time.Second
+)
+
+var (
+	clusterStatusSets = &sync.Map{} // clusterName -> *ClusterStatus
+	healthCheckOnce   sync.Once
+)
+
+// ClusterStatus is the result of the latest health probe of a cluster.
+type ClusterStatus struct {
+	Name        string        `json:"name"`
+	Healthy     bool          `json:"healthy"`
+	Ready       bool          `json:"ready"` // informer cache synced
+	Version     string        `json:"version"`
+	Checks      []CheckResult `json:"checks"`
+	LastCheck   time.Time     `json:"lastCheck"`
+	LastSuccess time.Time     `json:"lastSuccess"`
+	LastError   string        `json:"lastError"`
+}
+
+type CheckResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// ListClusterStatus returns the status of every configured cluster, including the ones that failed to build.
+func ListClusterStatus() []ClusterStatus {
+	var out []ClusterStatus
+	clusterStatusSets.Range(func(_, value interface{}) bool {
+		status := *value.(*ClusterStatus)
+		if v, ok := clusterManagerSets.Load(status.Name); ok {
+			status.Ready = v.(*ClusterManager).Ready()
+		}
+		out = append(out, status)
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// CheckClusterHealthy returns why cluster name can't serve requests, or nil if it can or hasn't been probed yet.
+func CheckClusterHealthy(name string) error {
+	v, ok := clusterStatusSets.Load(name)
+	if !ok {
+		return nil
+	}
+	status := v.(*ClusterStatus)
+	if status.Healthy || status.LastCheck.IsZero() {
+		return nil
+	}
+	return fmt.Errorf("cluster %s is unhealthy since %s: %s", name, status.LastCheck.Format(time.RFC3339), status.LastError)
+}
+
+// recordClusterError marks a cluster that could not be built as unhealthy instead of dropping it silently.
+func recordClusterError(name string, err error) {
+	status := &ClusterStatus{Name: name, LastCheck: time.Now(), LastError: err.Error()}
+	if v, ok := clusterStatusSets.Load(name); ok {
+		status.LastSuccess = v.(*ClusterStatus).LastSuccess
+	}
+	clusterStatusSets.Store(name, status)
+}
+
+// startHealthChecks probes every cluster periodically, kube.healthCheckInterval defaults to 30s.
+func startHealthChecks() {
+	healthCheckOnce.Do(func() {
+		interval := econf.GetDuration("kube.healthCheckInterval")
+		if interval <= 0 {
+			interval = defaultHealthCheckInterval
+		}
+		go func() {
+			for {
+				checkAllClusters()
+				time.Sleep(interval)
+			}
+		}()
+	})
+}
+
+func checkAllClusters() {
+	var names []string
+	clusterManagerSets.Range(func(key, _ interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	for _, name := range names {
+		manager, err := GetClusterManager(name)
+		if err != nil {
+			continue
+		}
+		status := manager.probe()
+		manager.Release()
+		if !status.Healthy {
+			elog.Warn("cluster health check failed", zap.String("clusterName", name), zap.String("error", status.LastError))
+		}
+		recordProbe(manager, status)
+	}
+}
+
+// recordProbe stores the status probed for m unless a reload replaced or removed m meanwhile,
+// a late probe must not bring back a removed cluster or overwrite the status of the new manager.
+func recordProbe(m *ClusterManager, status *ClusterStatus) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if v, ok := clusterManagerSets.Load(m.Cluster.Name); !ok || v.(*ClusterManager) != m {
+		return
+	}
+	clusterStatusSets.Store(m.Cluster.Name, status)
+}
+
+// probe checks apiServer version, that the credentials are accepted and that pods/proxy is allowed.
+func (m *ClusterManager) probe() *ClusterStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	status := &ClusterStatus{Name: m.Cluster.Name, LastCheck: time.Now(), Healthy: true}
+	if v, ok := clusterStatusSets.Load(m.Cluster.Name); ok {
+		status.LastSuccess = v.(*ClusterStatus).LastSuccess
+	}
+	record := func(name string, err error) {
+		result := CheckResult{Name: name, OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			if status.Healthy {
+				status.Healthy = false
+				status.LastError = fmt.Sprintf("%s check failed: %s", name, err.Error())
+			}
+		}
+		status.Checks = append(status.Checks, result)
+	}
+
+	version, err := m.Client.Discovery().ServerVersion()
+	if err == nil {
+		status.Version = version.String()
+	}
+	record("version", err)
+	// version is often readable anonymously, a SelfSubjectAccessReview requires valid credentials
+	record("auth", m.checkAccess(ctx, "list", "pods", ""))
+	record("podsProxy", m.checkAccess(ctx, "get", "pods", "proxy"))
+
+	if status.Healthy {
+		status.LastSuccess = status.LastCheck
+	}
+	return status
+}
+
+func (m *ClusterManager) checkAccess(ctx context.Context, verb, resource, subresource string) error {
+	review, err := m.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:        verb,
+				Resource:    resource,
+				Subresource: subresource,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		target := resource
+		if subresource != "" {
+			target += "/" + subresource
+		}
+		return fmt.Errorf("%s %s is not allowed: %s", verb, target, review.Status.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package kube
+
+import "testing"
+
+func TestRecordProbe(t *testing.T) {
+	current := &ClusterManager{Cluster: &Cluster{Name: "probe"}}
+	retired := &ClusterManager{Cluster: &Cluster{Name: "probe"}}
+	clusterManagerSets.Store("probe", current)
+	defer clusterManagerSets.Delete("probe")
+	defer clusterStatusSets.Delete("probe")
+
+	recordProbe(retired, &ClusterStatus{Name: "probe", LastError: "retired"})
+	if _, ok := clusterStatusSets.Load("probe"); ok {
+		t.Fatal("status of a replaced manager stored")
+	}
+	recordProbe(current, &ClusterStatus{Name: "probe", Healthy: true})
+	if v, ok := clusterStatusSets.Load("probe"); !ok || !v.(*ClusterStatus).Healthy {
+		t.Fatal("status of the current manager not stored")
+	}
+	clusterManagerSets.Delete("probe")
+	clusterStatusSets.Delete("probe")
+	recordProbe(current, &ClusterStatus{Name: "probe", Healthy: true})
+	if _, ok := clusterStatusSets.Load("probe"); ok {
+		t.Fatal("status of a removed cluster brought back")
+	}
+}
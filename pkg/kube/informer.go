@@ -0,0 +1,189 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotomicro/ego/core/econf"
+	"github.com/gotomicro/ego/core/elog"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultResyncPeriod     = 10 * time.Minute
+	defaultCacheSyncTimeout = 60 * time.Second
+)
+
+// Workload is the top level controller that owns a pod.
+type Workload struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// clusterCache holds the shared informers of one cluster. ReplicaSets are cached
+// so a pod can be resolved to its Deployment without hitting apiServer.
+type clusterCache struct {
+	factory informers.SharedInformerFactory
+	stopCh  chan struct{}
+	ready   int32
+
+	pods              corelisters.PodLister
+	podsSynced        cache.InformerSynced
+	replicaSets       appslisters.ReplicaSetLister
+	replicaSetsSynced cache.InformerSynced
+}
+
+func newClusterCache(manager *ClusterManager) *clusterCache {
+	resync := econf.GetDuration("kube.resyncPeriod")
+	if resync <= 0 {
+		resync = defaultResyncPeriod
+	}
+	factory := informers.NewSharedInformerFactory(manager.Client, resync)
+	pods := factory.Core().V1().Pods()
+	replicaSets := factory.Apps().V1().ReplicaSets()
+	return &clusterCache{
+		factory:           factory,
+		stopCh:            make(chan struct{}),
+		pods:              pods.Lister(),
+		podsSynced:        pods.Informer().HasSynced,
+		replicaSets:       replicaSets.Lister(),
+		replicaSetsSynced: replicaSets.Informer().HasSynced,
+	}
+}
+
+// start runs the informers and marks the cache ready once every informer has synced.
+// Until then lookups fall back to apiServer.
+func (c *clusterCache) start(clusterName string) {
+	c.factory.Start(c.stopCh)
+	go func() {
+		timeout := econf.GetDuration("kube.cacheSyncTimeout")
+		if timeout <= 0 {
+			timeout = defaultCacheSyncTimeout
+		}
+		begin := time.Now()
+		warned := false
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stopCh:
+				return
+			case <-ticker.C:
+			}
+			if c.hasSynced() {
+				atomic.StoreInt32(&c.ready, 1)
+				elog.Info("cluster cache synced", zap.String("clusterName", clusterName), zap.Duration("cost", time.Since(begin)))
+				return
+			}
+			if !warned && time.Since(begin) > timeout {
+				warned = true
+				elog.Warn("cluster cache not synced yet, serving lookups from apiServer", zap.String("clusterName", clusterName), zap.Duration("waited", time.Since(begin)))
+			}
+		}
+	}()
+}
+
+func (c *clusterCache) hasSynced() bool {
+	return c.podsSynced() && c.replicaSetsSynced()
+}
+
+func (c *clusterCache) stop() {
+	select {
+	case <-c.stopCh:
+	default:
+		close(c.stopCh)
+	}
+}
+
+func (c *clusterCache) isReady() bool {
+	return atomic.LoadInt32(&c.ready) == 1
+}
+
+// Ready reports whether the informer cache of this cluster has synced.
+func (m *ClusterManager) Ready() bool {
+	return m.cache != nil && m.cache.isReady()
+}
+
+// GetPod returns a pod from the informer cache, falling back to apiServer until the cache is ready.
+func (m *ClusterManager) GetPod(namespace, name string) (*corev1.Pod, error) {
+	if m.Ready() {
+		pod, err := m.cache.pods.Pods(namespace).Get(name)
+		if err != nil {
+			return nil, wrapNotFound(err, "pod", namespace, name)
+		}
+		return pod, nil
+	}
+	pod, err := m.Client.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, wrapNotFound(err, "pod", namespace, name)
+	}
+	return pod, nil
+}
+
+// ListPods returns the pods of a namespace matching selector, an empty namespace lists all namespaces.
+func (m *ClusterManager) ListPods(namespace string, selector labels.Selector) ([]*corev1.Pod, error) {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	if m.Ready() {
+		if namespace == "" {
+			return m.cache.pods.List(selector)
+		}
+		return m.cache.pods.Pods(namespace).List(selector)
+	}
+	list, err := m.Client.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*corev1.Pod, 0, len(list.Items))
+	for i := range list.Items {
+		out = append(out, &list.Items[i])
+	}
+	return out, nil
+}
+
+// GetPodWorkload resolves the workload owning pod, following ReplicaSet to Deployment.
+// A pod without a controller is reported as its own workload.
+func (m *ClusterManager) GetPodWorkload(pod *corev1.Pod) Workload {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return Workload{Kind: "Pod", Name: pod.Name}
+	}
+	if owner.Kind != "ReplicaSet" {
+		return Workload{Kind: owner.Kind, Name: owner.Name}
+	}
+	var (
+		rs  *appsv1.ReplicaSet
+		err error
+	)
+	if m.Ready() {
+		rs, err = m.cache.replicaSets.ReplicaSets(pod.Namespace).Get(owner.Name)
+	} else {
+		rs, err = m.Client.AppsV1().ReplicaSets(pod.Namespace).Get(context.Background(), owner.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return Workload{Kind: owner.Kind, Name: owner.Name}
+	}
+	if rsOwner := metav1.GetControllerOf(rs); rsOwner != nil {
+		return Workload{Kind: rsOwner.Kind, Name: rsOwner.Name}
+	}
+	return Workload{Kind: owner.Kind, Name: owner.Name}
+}
+
+func wrapNotFound(err error, resource, namespace, name string) error {
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%s %s/%s not found: %w", resource, namespace, name, err)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package kube
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListPods(t *testing.T) {
+	pod := func(namespace, name, app string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"app": app}}}
+	}
+	manager := &ClusterManager{
+		Cluster: &Cluster{Name: "test"},
+		Client:  fake.NewSimpleClientset(pod("a", "api-1", "api"), pod("a", "web-1", "web"), pod("b", "api-2", "api")),
+	}
+	manager.cache = newClusterCache(manager)
+	defer manager.cache.stop()
+
+	api := labels.SelectorFromSet(labels.Set{"app": "api"})
+	tests := []struct {
+		namespace string
+		selector  labels.Selector
+		want      []string
+	}{
+		{"a", nil, []string{"a/api-1", "a/web-1"}},
+		{"", api, []string{"a/api-1", "b/api-2"}},
+		{"b", api, []string{"b/api-2"}},
+		{"c", nil, []string{}},
+	}
+	check := func(source string) {
+		for _, tc := range tests {
+			pods, err := manager.ListPods(tc.namespace, tc.selector)
+			if err != nil {
+				t.Fatalf("%s: ListPods(%q) error: %v", source, tc.namespace, err)
+			}
+			got := make([]string, 0, len(pods))
+			for _, p := range pods {
+				got = append(got, p.Namespace+"/"+p.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s: ListPods(%q, %v) = %v, want %v", source, tc.namespace, tc.selector, got, tc.want)
+			}
+		}
+	}
+
+	// apiServer serves lookups until the informers have synced
+	check("apiServer")
+	manager.cache.start("test")
+	deadline := time.Now().Add(10 * time.Second)
+	for !manager.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("informer cache not synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	check("informer")
+}
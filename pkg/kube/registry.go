@@ -0,0 +1,152 @@
+package kube
+
+import (
+	"fmt"
+
+	"github.com/gotomicro/ego/core/econf"
+)
+
+// ListClusters returns all clusters without their inline kubeconfig.
+func ListClusters() ([]*Cluster, error) {
+	clusters, err := GetAllClusters()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Cluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		c := *cluster
+		c.KubeConfigContent = ""
+		c.Token = ""
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
+// TestCluster builds a client for cluster and asks apiServer for its version.
+func TestCluster(cluster *Cluster) (string, error) {
+	data, err := cluster.kubeConfigData()
+	if err != nil {
+		return "", err
+	}
+	clientSet, _, err := buildClient(cluster.ApiServer, data, cluster.buildOptions()...)
+	if err != nil {
+		return "", err
+	}
+	version, err := clientSet.Discovery().ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("get server version error: %w", err)
+	}
+	return version.String(), nil
+}
+
+// CreateCluster validates and persists a cluster registered through the API, then reloads the managers.
+func CreateCluster(cluster *Cluster) error {
+	if err := validateAPICluster(cluster); err != nil {
+		return err
+	}
+	if isConfigCluster(cluster.Name) {
+		return ErrClusterExists
+	}
+	if _, err := TestCluster(cluster); err != nil {
+		return err
+	}
+	err := defaultClusterStore().modify(func(clusters []*Cluster) ([]*Cluster, error) {
+		for _, c := range clusters {
+			if c.Name == cluster.Name {
+				return nil, ErrClusterExists
+			}
+		}
+		return append(clusters, cluster), nil
+	})
+	if err != nil {
+		return err
+	}
+	ReloadClusters()
+	return nil
+}
+
+// UpdateCluster replaces the cluster registered under name. An empty kubeconfig keeps the stored one.
+// The cluster is tested before the store is locked, the update fails if the stored one changed meanwhile.
+func UpdateCluster(name string, cluster *Cluster) error {
+	cluster.Name = name
+	if isConfigCluster(name) {
+		return ErrClusterReadOnly
+	}
+	store := defaultClusterStore()
+	stored, err := store.get(name)
+	if err != nil {
+		return err
+	}
+	if cluster.KubeConfigContent == "" {
+		cluster.KubeConfigContent = stored.KubeConfigContent
+	}
+	if cluster.Token == "" {
+		cluster.Token = stored.Token
+	}
+	if err = validateAPICluster(cluster); err != nil {
+		return err
+	}
+	if _, err = TestCluster(cluster); err != nil {
+		return err
+	}
+	err = store.modify(func(clusters []*Cluster) ([]*Cluster, error) {
+		for i, c := range clusters {
+			if c.Name != name {
+				continue
+			}
+			if c.KubeConfigContent != stored.KubeConfigContent || c.Token != stored.Token {
+				return nil, ErrClusterChanged
+			}
+			clusters[i] = cluster
+			return clusters, nil
+		}
+		return nil, ErrClusterNotFound
+	})
+	if err != nil {
+		return err
+	}
+	ReloadClusters()
+	return nil
+}
+
+// DeleteCluster removes a cluster registered through the API, in-flight requests keep their manager until done.
+func DeleteCluster(name string) error {
+	if isConfigCluster(name) {
+		return ErrClusterReadOnly
+	}
+	err := defaultClusterStore().modify(func(clusters []*Cluster) ([]*Cluster, error) {
+		for i, c := range clusters {
+			if c.Name == name {
+				return append(clusters[:i], clusters[i+1:]...), nil
+			}
+		}
+		return nil, ErrClusterNotFound
+	})
+	if err != nil {
+		return err
+	}
+	ReloadClusters()
+	return nil
+}
+
+func validateAPICluster(cluster *Cluster) error {
+	// API clusters only carry inline credentials, never read files from goprobe's disk
+	cluster.KubeConfig = ""
+	cluster.TokenFile = ""
+	cluster.CAFile = ""
+	cluster.Source = ClusterSourceAPI
+	return cluster.validate()
+}
+
+func isConfigCluster(name string) bool {
+	var clusters []*Cluster
+	if err := econf.UnmarshalKey("cluster", &clusters); err != nil {
+		return false
+	}
+	for _, c := range clusters {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package kube
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gotomicro/ego/core/econf"
+	"github.com/gotomicro/ego/core/elog"
+	"go.uber.org/zap"
+)
+
+// kubeconfig writers (editors, configmap mounts) emit bursts of events, wait for them to settle.
+const reloadDebounce = time.Second
+
+var (
+	reloadMu       sync.Mutex
+	kubeConfigDirs = map[string]struct{}{}
+	watcher        *fsnotify.Watcher
+)
+
+// ReloadClusters diffs the configured clusters against the running managers,
+// building added clusters, rebuilding changed ones and retiring removed ones.
+// A cluster whose rebuild fails keeps its previous manager.
+func ReloadClusters() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	newClusters, err := GetAllClusters()
+	if err != nil {
+		elog.Error("get all normal(status==0) clusters error while building apiServer client.", zap.Error(err))
+		return
+	}
+
+	seen := make(map[string]struct{}, len(newClusters))
+	for i := 0; i < len(newClusters); i++ {
+		cluster := newClusters[i]
+		seen[cluster.Name] = struct{}{}
+		// deal with invalid cluster
+		if err := cluster.validate(); err != nil {
+			elog.Warn("invalid cluster", zap.String("clusterName", cluster.Name), zap.Error(err))
+			recordClusterError(cluster.Name, err)
+			continue
+		}
+
+		fingerprint := clusterFingerprint(cluster)
+		var old *ClusterManager
+		if v, ok := clusterManagerSets.Load(cluster.Name); ok {
+			old = v.(*ClusterManager)
+			if old.fingerprint == fingerprint {
+				continue
+			}
+		}
+
+		manager, err := newClusterManager(cluster, fingerprint)
+		if err != nil {
+			elog.Warn("build cluster's client error.", zap.String("clusterName", cluster.Name), zap.Bool("keepOld", old != nil), zap.Error(err))
+			if old == nil {
+				recordClusterError(cluster.Name, fmt.Errorf("build client error: %w", err))
+			}
+			continue
+		}
+		clusterManagerSets.Store(cluster.Name, manager)
+		go func() {
+			recordProbe(manager, manager.probe())
+		}()
+		if old == nil {
+			elog.Info("cluster added", zap.String("clusterName", cluster.Name), zap.String("apiServer", cluster.ApiServer))
+			continue
+		}
+		old.retire()
+		elog.Info("cluster rebuilt", zap.String("clusterName", cluster.Name),
+			zap.String("oldApiServer", old.Cluster.ApiServer), zap.String("apiServer", cluster.ApiServer))
+	}
+
+	clusterManagerSets.Range(func(key, value interface{}) bool {
+		if _, ok := seen[key.(string)]; ok {
+			return true
+		}
+		clusterManagerSets.Delete(key)
+		value.(*ClusterManager).retire()
+		elog.Info("cluster removed", zap.String("clusterName", key.(string)))
+		return true
+	})
+	clusterStatusSets.Range(func(key, _ interface{}) bool {
+		if _, ok := seen[key.(string)]; !ok {
+			clusterStatusSets.Delete(key)
+		}
+		return true
+	})
+
+	watchKubeConfigs(newClusters)
+}
+
+// clusterFingerprint changes whenever the cluster config or the content of its kubeconfig changes.
+func clusterFingerprint(cluster *Cluster) string {
+	h := sha256.New()
+	raw, _ := json.Marshal(cluster)
+	h.Write(raw)
+	if content, err := ioutil.ReadFile(cluster.KubeConfig); err == nil {
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// watchClusterConfig reloads clusters on config changes and kubeconfig file changes.
+func watchClusterConfig() {
+	econf.OnChange(func(*econf.Configuration) {
+		elog.Info("config changed, reloading clusters")
+		ReloadClusters()
+	})
+
+	var err error
+	watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		elog.Error("new kubeconfig watcher error, kubeconfig changes need a restart", zap.Error(err))
+		return
+	}
+	reloadMu.Lock()
+	clusters, _ := GetAllClusters()
+	watchKubeConfigs(clusters)
+	reloadMu.Unlock()
+
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				debounce = time.After(reloadDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				elog.Warn("kubeconfig watcher error", zap.Error(err))
+			case <-debounce:
+				elog.Info("kubeconfig changed, reloading clusters")
+				ReloadClusters()
+			}
+		}
+	}()
+}
+
+// watchKubeConfigs watches the directories holding kubeconfig files, files are
+// usually replaced by rename so watching the file itself would lose track of it.
+// Must be called with reloadMu held.
+func watchKubeConfigs(clusters []*Cluster) {
+	if watcher == nil {
+		return
+	}
+	dirs := make(map[string]struct{})
+	for _, cluster := range clusters {
+		if cluster.KubeConfig == "" {
+			continue
+		}
+		dirs[filepath.Dir(cluster.KubeConfig)] = struct{}{}
+	}
+	for dir := range dirs {
+		if _, ok := kubeConfigDirs[dir]; ok {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			elog.Warn("watch kubeconfig dir error", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		kubeConfigDirs[dir] = struct{}{}
+	}
+	for dir := range kubeConfigDirs {
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+		_ = watcher.Remove(dir)
+		delete(kubeConfigDirs, dir)
+	}
+}
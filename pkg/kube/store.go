@@ -0,0 +1,117 @@
+package kube
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gotomicro/ego/core/econf"
+)
+
+const defaultClusterStorePath = "./tmp/goprobe/clusters.json"
+
+var (
+	ErrClusterExists   = errors.New("cluster already exists")
+	ErrClusterNotFound = errors.New("cluster not found")
+	ErrClusterReadOnly = errors.New("cluster is defined in config and can't be changed through the API")
+	ErrClusterChanged  = errors.New("cluster was changed by another request, retry")
+)
+
+// clusterStore persists clusters registered through the API as a json file.
+type clusterStore struct {
+	path string
+}
+
+func defaultClusterStore() *clusterStore {
+	path := econf.GetString("kube.clusterStorePath")
+	if path == "" {
+		path = defaultClusterStorePath
+	}
+	return &clusterStore{path: path}
+}
+
+// storeMu serializes read-modify-write cycles on the store file across clusterStore values.
+var storeMu sync.Mutex
+
+func (s *clusterStore) list() ([]*Cluster, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read cluster store error: %w", err)
+	}
+	var clusters []*Cluster
+	if err = json.Unmarshal(data, &clusters); err != nil {
+		return nil, fmt.Errorf("unmarshal cluster store error: %w", err)
+	}
+	for _, cluster := range clusters {
+		cluster.Source = ClusterSourceAPI
+	}
+	return clusters, nil
+}
+
+// save writes clusters to a temp file and renames it over the store so a crash never leaves a partial file.
+func (s *clusterStore) save(clusters []*Cluster) error {
+	data, err := json.MarshalIndent(clusters, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("mkdir cluster store error: %w", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("create cluster store temp file error: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write cluster store error: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync cluster store error: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	// kubeconfig contents are credentials
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// modify applies fn to the stored clusters and saves the result.
+// get returns the stored cluster named name.
+func (s *clusterStore) get(name string) (*Cluster, error) {
+	clusters, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range clusters {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, ErrClusterNotFound
+}
+
+func (s *clusterStore) modify(fn func([]*Cluster) ([]*Cluster, error)) error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	clusters, err := s.list()
+	if err != nil {
+		return err
+	}
+	clusters, err = fn(clusters)
+	if err != nil {
+		return err
+	}
+	return s.save(clusters)
+}
@@ -0,0 +1,39 @@
+package kube
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestClusterStore(t *testing.T) {
+	s := &clusterStore{path: filepath.Join(t.TempDir(), "clusters.json")}
+	clusters, err := s.list()
+	if err != nil || len(clusters) != 0 {
+		t.Fatalf("empty store: clusters=%v err=%v", clusters, err)
+	}
+	err = s.modify(func(clusters []*Cluster) ([]*Cluster, error) {
+		return append(clusters, &Cluster{Name: "a", ApiServer: "https://a:6443", KubeConfigContent: "{}"}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.modify(func(clusters []*Cluster) ([]*Cluster, error) {
+		return nil, ErrClusterExists
+	})
+	if err != ErrClusterExists {
+		t.Fatalf("modify error = %v, want %v", err, ErrClusterExists)
+	}
+	clusters, err = s.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].Name != "a" || clusters[0].Source != ClusterSourceAPI {
+		t.Fatalf("unexpected clusters: %+v", clusters)
+	}
+	if c, err := s.get("a"); err != nil || c.KubeConfigContent != "{}" {
+		t.Fatalf("get a = %+v, %v", c, err)
+	}
+	if _, err = s.get("b"); err != ErrClusterNotFound {
+		t.Fatalf("get b error = %v, want %v", err, ErrClusterNotFound)
+	}
+}
@@ -0,0 +1,141 @@
+package pprof
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gotomicro/ego/core/elog"
+	"go.uber.org/zap"
+
+	"goprobe/pkg/dto"
+	"goprobe/pkg/storage"
+)
+
+// auditPrefix is a reserved top level key, see isReservedKey.
+const auditPrefix = "_audit"
+
+const (
+	AuditActionDelete = "delete"
+	AuditActionUpdate = "update"
+)
+
+// AuditRecord records who changed which captures.
+type AuditRecord struct {
+	Time     int64    `json:"time"`
+	Operator string   `json:"operator"`
+	Action   string   `json:"action"`
+	Keys     []string `json:"keys"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// audit persists record under _audit/<date>/ and logs it.
+func (p *pprof) audit(ctx context.Context, record AuditRecord) {
+	now := time.Now()
+	record.Time = now.Unix()
+	elog.Info("audit", zap.String("operator", record.Operator), zap.String("action", record.Action),
+		zap.Strings("keys", record.Keys), zap.String("detail", record.Detail))
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	key := path.Join(auditPrefix, now.Format("2006-01-02"), fmt.Sprintf("%d_%s.json", now.UnixNano(), record.Action))
+	if err = p.storage.PutBytes(ctx, key, data); err != nil {
+		elog.Error("save audit record error", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// ListAudit returns the audit records of a day (2006-01-02), newest first.
+func (p *pprof) ListAudit(date string) ([]AuditRecord, error) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return nil, fmt.Errorf("invalid date %s: %w", date, err)
+	}
+	ctx := context.TODO()
+	dir := path.Join(auditPrefix, date)
+	names, err := p.storage.List(ctx, dir)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return []AuditRecord{}, nil
+		}
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	list := make([]AuditRecord, 0, len(names))
+	for _, name := range names {
+		data, err := p.storage.GetBytes(ctx, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var record AuditRecord
+		if err = json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("unmarshal audit record %s error: %w", name, err)
+		}
+		list = append(list, record)
+	}
+	return list, nil
+}
+
+// DeleteCapture deletes a capture with all of its artifacts.
+func (p *pprof) DeleteCapture(key, operator string) error {
+	c, err := p.GetCapture(key)
+	if err != nil {
+		return err
+	}
+	if c.Status == CaptureStatusRunning {
+		return fmt.Errorf("capture %s is still running", c.Key)
+	}
+	ctx := context.TODO()
+	err = p.deleteCapture(ctx, c.Key)
+	if err != nil {
+		return err
+	}
+	p.audit(ctx, AuditRecord{Operator: operator, Action: AuditActionDelete, Keys: []string{c.Key}})
+	return nil
+}
+
+// DeleteCaptures deletes every finished capture matching the filter and returns their keys.
+func (p *pprof) DeleteCaptures(req dto.ReqDeleteCaptures, operator string) (deleted []string, err error) {
+	if req.ClusterName == "" && req.Namespace == "" && req.PodName == "" && req.Before == 0 {
+		return nil, fmt.Errorf("at least one of clusterName, namespace, podName or before is required")
+	}
+	captures := p.index.list(func(c *Capture) bool {
+		switch {
+		case c.Status == CaptureStatusRunning:
+			return false
+		case c.Pinned && !req.IncludePinned:
+			return false
+		case req.ClusterName != "" && c.ClusterName != req.ClusterName:
+			return false
+		case req.Namespace != "" && c.Namespace != req.Namespace:
+			return false
+		case req.PodName != "" && !strings.HasPrefix(c.PodName, req.PodName) && !strings.HasPrefix(c.Addr, req.PodName):
+			return false
+		case req.Status != "" && c.Status != req.Status:
+			return false
+		case req.Before != 0 && c.Ctime >= req.Before:
+			return false
+		case req.After != 0 && c.Ctime < req.After:
+			return false
+		}
+		return true
+	})
+	ctx := context.TODO()
+	deleted = make([]string, 0, len(captures))
+	for _, c := range captures {
+		if err = p.deleteCapture(ctx, c.Key); err != nil {
+			err = fmt.Errorf("delete capture %s error: %w", c.Key, err)
+			break
+		}
+		deleted = append(deleted, c.Key)
+	}
+	if len(deleted) > 0 {
+		filter, _ := json.Marshal(req)
+		p.audit(ctx, AuditRecord{Operator: operator, Action: AuditActionDelete, Keys: deleted, Detail: string(filter)})
+	}
+	return
+}
@@ -0,0 +1,195 @@
+package pprof
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotomicro/ego/core/elog"
+	"go.uber.org/zap"
+
+	"goprobe/pkg/storage"
+)
+
+// Raw profiles and their renderings are stored once per content hash as
+// _blobs/sha256/<hash[:2]>/<hash>/{raw.bin,flame.svg,profile.svg,...}, captures reference them by hash.
+// Only raw.bin is written at capture time, graphs are rendered on their first request.
+const (
+	blobPrefix  = "_blobs/sha256"
+	blobRawName = "raw.bin"
+)
+
+// blobGracePeriod protects blobs being written by another replica from the orphan sweep.
+const blobGracePeriod = time.Hour
+
+// ProfileRef points a profile kind of a capture at its content-addressed blob.
+type ProfileRef struct {
+	Hash string `json:"hash"`
+	// Size is the stored bytes of the blob: raw profile and the renderings cached so far. Graphs rendered
+	// or dropped later update it in every capture referencing the blob, see recordArtifactBytes.
+	Size int64 `json:"size"`
+}
+
+func hashProfile(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func blobDir(hash string) string {
+	return path.Join(blobPrefix, hash[:2], hash)
+}
+
+// blobRefs counts the blobs referenced by captures still running, which aren't in the index yet.
+type blobRefs struct {
+	mu      sync.Mutex
+	pending map[string]int
+}
+
+func (b *blobRefs) acquire(hash string) {
+	b.mu.Lock()
+	if b.pending == nil {
+		b.pending = make(map[string]int)
+	}
+	b.pending[hash]++
+	b.mu.Unlock()
+}
+
+func (b *blobRefs) release(hash string) {
+	b.mu.Lock()
+	if b.pending[hash]--; b.pending[hash] <= 0 {
+		delete(b.pending, hash)
+	}
+	b.mu.Unlock()
+}
+
+// artifactKey is the storage key of an artifact of a profile kind, e.g. raw.bin or flame.svg.
+// Captures stored before deduplication keep their artifacts as <key>/<kind>.bin and <key>/<kind>_<name>.
+func artifactKey(c *Capture, kind, name string) string {
+	if ref, ok := c.Profiles[kind]; ok {
+		return path.Join(blobDir(ref.Hash), name)
+	}
+	return path.Join(c.Key, legacyArtifactName(kind, name))
+}
+
+func legacyArtifactName(kind, name string) string {
+	if name == blobRawName {
+		return kind + ".bin"
+	}
+	return kind + "_" + name
+}
+
+// blobSize sums the stored bytes of every file of a blob.
+func (p *pprof) blobSize(ctx context.Context, hash string) (int64, error) {
+	dir := blobDir(hash)
+	names, err := p.storage.List(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, name := range names {
+		info, err := p.storage.Stat(ctx, path.Join(dir, name))
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size
+	}
+	return size, nil
+}
+
+// recordArtifactBytes adds delta to the stored bytes recorded for the artifacts of a profile kind of c,
+// as graphs are rendered into storage or dropped, so the retention GC doesn't measure storage.
+// A blob is shared, its size is updated in every capture referencing it.
+func (p *pprof) recordArtifactBytes(ctx context.Context, c *Capture, kind string, delta int64) {
+	p.sizeMu.Lock()
+	defer p.sizeMu.Unlock()
+	ref, ok := c.Profiles[kind]
+	if !ok {
+		_, err := p.updateCapture(ctx, c.Key, func(c *Capture) error {
+			c.Size += delta
+			return nil
+		})
+		if err != nil {
+			elog.Warn("record artifact size error", zap.String("key", c.Key), zap.Error(err))
+		}
+		return
+	}
+	// captures saved before the last update of the blob recorded less, the largest size is the latest
+	size := ref.Size
+	var keys []string
+	for _, other := range p.index.list(nil) {
+		for _, r := range other.Profiles {
+			if r.Hash == ref.Hash {
+				if r.Size > size {
+					size = r.Size
+				}
+				keys = append(keys, other.Key)
+				break
+			}
+		}
+	}
+	size += delta
+	for _, key := range keys {
+		_, err := p.updateCapture(ctx, key, func(c *Capture) error {
+			profiles := make(map[string]ProfileRef, len(c.Profiles))
+			for k, r := range c.Profiles {
+				if r.Hash == ref.Hash {
+					c.Size += size - r.Size
+					r.Size = size
+				}
+				profiles[k] = r
+			}
+			c.Profiles = profiles
+			return nil
+		})
+		if err != nil {
+			elog.Warn("record blob size error", zap.String("key", key), zap.String("hash", ref.Hash), zap.Error(err))
+		}
+	}
+}
+
+// sweepBlobs deletes the blobs no capture references: those of deleted or symbolized captures and
+// those left behind by failed renderings or crashes. Blobs aren't deleted as soon as this replica stops
+// referencing them, a capture of another replica sharing the storage may not be indexed here yet;
+// the grace period covers it until the index refresh picks it up.
+func (p *pprof) sweepBlobs(ctx context.Context, now time.Time) (swept int, err error) {
+	shards, err := p.storage.List(ctx, blobPrefix)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("list blobs error: %w", err)
+	}
+	for _, shard := range shards {
+		hashes, err := p.storage.List(ctx, path.Join(blobPrefix, shard))
+		if err != nil {
+			elog.Warn("list blob shard error", zap.String("shard", shard), zap.Error(err))
+			continue
+		}
+		for _, hash := range hashes {
+			if !strings.HasPrefix(hash, shard) {
+				continue
+			}
+			info, err := p.storage.Stat(ctx, path.Join(blobDir(hash), blobRawName))
+			if err != nil || now.Sub(info.ModTime) < blobGracePeriod {
+				continue
+			}
+			p.blobs.mu.Lock()
+			if p.blobs.pending[hash] == 0 && !p.index.referencesBlob(hash) {
+				if err = p.storage.DeletePrefix(ctx, blobDir(hash)); err == nil {
+					swept++
+				}
+			}
+			p.blobs.mu.Unlock()
+			if err != nil {
+				elog.Warn("sweep blob error", zap.String("hash", hash), zap.Error(err))
+			}
+		}
+	}
+	return swept, nil
+}
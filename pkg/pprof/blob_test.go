@@ -0,0 +1,121 @@
+package pprof
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"goprobe/pkg/storage"
+	"goprobe/pkg/storage/filesystem"
+)
+
+func TestSharedBlobDelete(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	raw := []byte("heap profile")
+	hash := hashProfile(raw)
+	for _, name := range []string{blobRawName, "flame.svg", "profile.svg"} {
+		if err := p.storage.PutBytes(ctx, path.Join(blobDir(hash), name), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a stored blob is reused along with its cached renderings
+	size, err := p.saveRaw(raw, hash, "heap")
+	if size != 3*int64(len(raw)) || err != nil {
+		t.Fatalf("saveRaw() = %d, %v, want %d", size, err, 3*len(raw))
+	}
+	keys := []string{"c/ns/pod_1", "c/ns/pod_2"}
+	for _, key := range keys {
+		c := &Capture{Key: key, Status: CaptureStatusSuccess, Kinds: []string{"heap"}, Profiles: map[string]ProfileRef{"heap": {Hash: hash, Size: size}}}
+		if err := p.saveCapture(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, _ := p.GetCapture(keys[0])
+	if got, want := artifactKey(c, "heap", blobRawName), path.Join(blobDir(hash), blobRawName); got != want {
+		t.Errorf("artifactKey() = %s, want %s", got, want)
+	}
+	if got, want := artifactKey(&Capture{Key: "c/ns/legacy_1"}, "heap", "flame.svg"), "c/ns/legacy_1/heap_flame.svg"; got != want {
+		t.Errorf("artifactKey() of a legacy capture = %s, want %s", got, want)
+	}
+	// graphs rendered into the shared blob count in both captures
+	if err := p.storeRender(ctx, c, "heap", path.Join(blobDir(hash), "flame_samples.svg"), raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if c, _ := p.GetCapture(key); c.Profiles["heap"].Size != 4*int64(len(raw)) {
+			t.Errorf("%s records blob size %d, want %d", key, c.Profiles["heap"].Size, 4*len(raw))
+		}
+	}
+
+	exists := func() bool {
+		ok, err := storage.Exists(ctx, p.storage, path.Join(blobDir(hash), blobRawName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	later := time.Now().Add(2 * blobGracePeriod)
+	if err := p.deleteCapture(ctx, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if swept, err := p.sweepBlobs(ctx, later); swept != 0 || err != nil || !exists() {
+		t.Fatalf("sweepBlobs() = %d, %v, want blob shared with another capture kept", swept, err)
+	}
+	// blobs are only deleted by the sweep, a capture of another replica may reference them
+	if err := p.deleteCapture(ctx, keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	if !exists() {
+		t.Fatal("blob deleted along with its last capture")
+	}
+	if swept, err := p.sweepBlobs(ctx, later); swept != 1 || err != nil || exists() {
+		t.Fatalf("sweepBlobs() = %d, %v, want blob without references swept", swept, err)
+	}
+}
+
+func TestReusedBlobKeptBySweep(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	p := &pprof{storage: filesystem.NewFilesystemClient(dir), index: newCaptureIndex()}
+	raw := []byte("heap profile")
+	hash := hashProfile(raw)
+	key := path.Join(blobDir(hash), blobRawName)
+	if err := p.storage.PutBytes(ctx, key, raw); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * blobGracePeriod)
+	if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), old, old); err != nil {
+		t.Fatal(err)
+	}
+	// another replica reuses the orphaned blob for a capture this one hasn't indexed yet
+	if _, err := p.saveRaw(raw, hash, "heap"); err != nil {
+		t.Fatal(err)
+	}
+	if swept, err := p.sweepBlobs(ctx, time.Now()); swept != 0 || err != nil {
+		t.Fatalf("sweepBlobs() = %d, %v, want reused blob kept", swept, err)
+	}
+}
+
+func TestSweepBlobs(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	hash := hashProfile([]byte("orphan"))
+	if err := p.storage.PutBytes(ctx, path.Join(blobDir(hash), blobRawName), []byte("orphan")); err != nil {
+		t.Fatal(err)
+	}
+	p.blobs.acquire(hash)
+	if swept, err := p.sweepBlobs(ctx, time.Now().Add(2*blobGracePeriod)); swept != 0 || err != nil {
+		t.Fatalf("sweepBlobs() = %d, %v, want pending blob kept", swept, err)
+	}
+	p.blobs.release(hash)
+	if swept, err := p.sweepBlobs(ctx, time.Now()); swept != 0 || err != nil {
+		t.Fatalf("sweepBlobs() = %d, %v, want fresh blob kept", swept, err)
+	}
+	if swept, err := p.sweepBlobs(ctx, time.Now().Add(2*blobGracePeriod)); swept != 1 || err != nil {
+		t.Fatalf("sweepBlobs() = %d, %v, want orphan swept", swept, err)
+	}
+}
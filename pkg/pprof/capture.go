@@ -0,0 +1,455 @@
+package pprof
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotomicro/ego/core/elog"
+	"github.com/spf13/cast"
+	"go.uber.org/zap"
+
+	"goprobe/pkg/dto"
+	"goprobe/pkg/kube"
+	"goprobe/pkg/storage"
+)
+
+// manifestName is the metadata record stored next to the artifacts of every capture.
+const manifestName = "meta.json"
+
+const (
+	CaptureStatusRunning = "running"
+	CaptureStatusSuccess = "success"
+	CaptureStatusPartial = "partial"
+	CaptureStatusFailed  = "failed"
+)
+
+const (
+	TriggerManual    = "manual"
+	TriggerScheduled = "scheduled"
+	TriggerThreshold = "threshold"
+)
+
+// Capture is the metadata of one profiling run, persisted as <Key>/meta.json.
+type Capture struct {
+	Key         string `json:"key"`
+	Mode        string `json:"mode"`
+	ClusterName string `json:"clusterName"`
+	Namespace   string `json:"namespace"`
+	PodName     string `json:"podName"`
+	Port        int    `json:"port"`
+	Addr        string `json:"addr"`
+	Seconds     int    `json:"seconds"`
+	// SampleIndex is the sample type requested for the graphs, e.g. alloc_space.
+	SampleIndex string `json:"sampleIndex,omitempty"`
+	Operator    string `json:"operator"`
+	// Workload owning the pod at capture time, pod mode only.
+	Workload    *kube.Workload `json:"workload,omitempty"`
+	TriggeredBy string         `json:"triggeredBy"`
+	Kinds       []string       `json:"kinds"`
+	// Profiles maps each stored kind to its raw profile blob, empty for captures stored before deduplication.
+	Profiles map[string]ProfileRef `json:"profiles,omitempty"`
+	// BuildIDs maps each stored kind to the build ID of the executable mapping of its profile, when recorded.
+	BuildIDs map[string]string `json:"buildIDs,omitempty"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Ctime    int64             `json:"ctime"` // unix seconds
+	Mtime    int64             `json:"mtime"`
+	// Size is the total bytes of the artifacts stored for this capture.
+	Size int64 `json:"size"`
+	// Pinned captures are never removed by the retention GC.
+	Pinned bool              `json:"pinned"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Note   string            `json:"note,omitempty"`
+	// Binary is the file name of the target executable attached for disassembly, stored as <Key>/binary.
+	Binary string `json:"binary,omitempty"`
+	// Backfilled is set on captures whose metadata was reconstructed from the storage layout.
+	Backfilled bool `json:"backfilled,omitempty"`
+}
+
+func newCapture(req dto.ReqRunProfile, now time.Time) *Capture {
+	return &Capture{
+		Key:         req.UniqueKey,
+		Mode:        req.Mode,
+		ClusterName: req.ClusterName,
+		Namespace:   req.Namespace,
+		PodName:     req.PodName,
+		Port:        req.Port,
+		Addr:        req.Addr,
+		Seconds:     req.Seconds,
+		SampleIndex: req.SampleIndex,
+		Operator:    req.Operator,
+		TriggeredBy: req.TriggeredBy,
+		Kinds:       []string{},
+		Status:      CaptureStatusRunning,
+		Ctime:       now.Unix(),
+		Mtime:       now.Unix(),
+	}
+}
+
+// finish sets the final status from the kinds that succeeded and the first error.
+func (c *Capture) finish(err error) {
+	sort.Strings(c.Kinds)
+	c.Mtime = time.Now().Unix()
+	switch {
+	case err == nil:
+		c.Status = CaptureStatusSuccess
+	case len(c.Kinds) == 0:
+		c.Status = CaptureStatusFailed
+		c.Error = err.Error()
+	default:
+		c.Status = CaptureStatusPartial
+		c.Error = err.Error()
+	}
+}
+
+// staleRunningTimeout is how long after its profiling window a capture may still be running,
+// a manifest left running longer belongs to a process that died mid-capture.
+const staleRunningTimeout = 10 * time.Minute
+
+// interrupted reports whether c is still marked running long after it should have finished.
+func (c *Capture) interrupted(now time.Time) bool {
+	if c.Status != CaptureStatusRunning {
+		return false
+	}
+	deadline := time.Unix(c.Ctime, 0).Add(time.Duration(c.Seconds)*time.Second + staleRunningTimeout)
+	return now.After(deadline)
+}
+
+// ownSize is the bytes stored for the capture itself, excluding the blobs it shares.
+func (c *Capture) ownSize() int64 {
+	size := c.Size
+	for _, ref := range c.Profiles {
+		size -= ref.Size
+	}
+	return size
+}
+
+// captureIndex is an in-memory index over the manifests of all captures.
+type captureIndex struct {
+	mu       sync.RWMutex
+	captures map[string]*Capture
+}
+
+func newCaptureIndex() *captureIndex {
+	return &captureIndex{captures: make(map[string]*Capture)}
+}
+
+func (idx *captureIndex) put(c *Capture) {
+	cp := *c
+	cp.Kinds = append([]string(nil), c.Kinds...)
+	if c.Labels != nil {
+		cp.Labels = make(map[string]string, len(c.Labels))
+		for k, v := range c.Labels {
+			cp.Labels[k] = v
+		}
+	}
+	if c.Workload != nil {
+		workload := *c.Workload
+		cp.Workload = &workload
+	}
+	if c.Profiles != nil {
+		cp.Profiles = make(map[string]ProfileRef, len(c.Profiles))
+		for k, v := range c.Profiles {
+			cp.Profiles[k] = v
+		}
+	}
+	if c.BuildIDs != nil {
+		cp.BuildIDs = make(map[string]string, len(c.BuildIDs))
+		for k, v := range c.BuildIDs {
+			cp.BuildIDs[k] = v
+		}
+	}
+	idx.mu.Lock()
+	idx.captures[c.Key] = &cp
+	idx.mu.Unlock()
+}
+
+func (idx *captureIndex) get(key string) (*Capture, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	c, ok := idx.captures[key]
+	if !ok {
+		return nil, false
+	}
+	cp := *c
+	return &cp, true
+}
+
+func (idx *captureIndex) delete(key string) {
+	idx.mu.Lock()
+	delete(idx.captures, key)
+	idx.mu.Unlock()
+}
+
+// referencesBlob reports whether any capture references blob hash.
+func (idx *captureIndex) referencesBlob(hash string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for _, c := range idx.captures {
+		for _, ref := range c.Profiles {
+			if ref.Hash == hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replace swaps in a freshly loaded set of captures, keeping the ones written since the load began.
+func (idx *captureIndex) replace(captures map[string]*Capture, since int64) {
+	idx.mu.Lock()
+	for key, c := range idx.captures {
+		if _, ok := captures[key]; !ok && c.Mtime >= since {
+			captures[key] = c
+		}
+	}
+	idx.captures = captures
+	idx.mu.Unlock()
+}
+
+// list returns the captures matching filter, newest first.
+func (idx *captureIndex) list(filter func(*Capture) bool) []*Capture {
+	idx.mu.RLock()
+	out := make([]*Capture, 0)
+	for _, c := range idx.captures {
+		if filter == nil || filter(c) {
+			cp := *c
+			out = append(out, &cp)
+		}
+	}
+	idx.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Ctime != out[j].Ctime {
+			return out[i].Ctime > out[j].Ctime
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// saveCapture writes the manifest of c and updates the index.
+func (p *pprof) saveCapture(ctx context.Context, c *Capture) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = p.storage.PutBytes(ctx, path.Join(c.Key, manifestName), data)
+	if err != nil {
+		return fmt.Errorf("保存 capture 元数据失败: %w", err)
+	}
+	p.index.put(c)
+	return nil
+}
+
+// GetCapture returns the metadata of the capture stored under key.
+func (p *pprof) GetCapture(key string) (*Capture, error) {
+	c, ok := p.index.get(strings.Trim(key, "/"))
+	if !ok {
+		return nil, fmt.Errorf("capture %s: %w", key, storage.ErrNotFound)
+	}
+	return c, nil
+}
+
+// manifestTimeSlack covers the modification time resolution of the storages, S3 keeps whole seconds.
+// A manifest rewritten within the same second keeps its time, so recent ones are fetched again by the next load.
+const manifestTimeSlack = 2 * time.Second
+
+// loadIndex lists the objects of every cluster/namespace/capture directory and loads the manifests changed
+// since the previous load, unchanged captures are kept from the index.
+// Captures stored before manifests existed are backfilled from their directory name and files.
+func (p *pprof) loadIndex(ctx context.Context) error {
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	now := time.Now()
+	since := now.Unix()
+	captures := make(map[string]*Capture)
+	manifests := make(map[string]storage.ObjectInfo)
+	fetched := 0
+	clusters, err := p.storage.List(ctx, "")
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("list clusters error: %w", err)
+	}
+	for _, cluster := range clusters {
+		if isReservedKey(cluster) {
+			continue
+		}
+		// addr mode and uploaded captures without a cluster are stored as custom|upload/<target>_<unixMilli>,
+		// the others as <cluster>/<namespace>/<target>_<unixMilli>
+		depth := 3
+		if cluster == customNamespace || cluster == uploadNamespace {
+			depth = 2
+		}
+		objects, err := p.storage.ListObjects(ctx, cluster)
+		if err != nil {
+			elog.Warn("list captures error while loading capture index", zap.String("cluster", cluster), zap.Error(err))
+			continue
+		}
+		var keys []string
+		listed := make(map[string]storage.ObjectInfo)
+		for _, obj := range objects {
+			parts := strings.Split(obj.Key, "/")
+			if len(parts) <= depth {
+				continue
+			}
+			key := strings.Join(parts[:depth], "/")
+			if _, ok := listed[key]; !ok {
+				keys = append(keys, key)
+				listed[key] = storage.ObjectInfo{}
+			}
+			if len(parts) == depth+1 && parts[depth] == manifestName {
+				listed[key] = obj
+			}
+		}
+		for _, key := range keys {
+			info := listed[key]
+			if c, ok := p.unchangedCapture(key, info, now); ok {
+				captures[key] = c
+				manifests[key] = info
+				continue
+			}
+			c, err := p.loadCapture(ctx, key)
+			fetched++
+			if err != nil {
+				elog.Warn("load capture error", zap.String("key", key), zap.Error(err))
+				continue
+			}
+			captures[key] = c
+			// a manifest written by loadCapture, or too recent to tell rewrites apart, is fetched again next time
+			if c.Mtime < since && !info.ModTime.IsZero() && info.ModTime.Before(now.Add(-manifestTimeSlack)) {
+				manifests[key] = info
+			}
+		}
+	}
+	p.manifests = manifests
+	p.index.replace(captures, since)
+	elog.Info("capture index loaded", zap.Int("count", len(captures)), zap.Int("fetched", fetched))
+	return nil
+}
+
+// unchangedCapture returns the indexed capture key if its manifest has the size and time recorded by the
+// previous load, and it doesn't need to be marked interrupted.
+func (p *pprof) unchangedCapture(key string, info storage.ObjectInfo, now time.Time) (*Capture, bool) {
+	prev, ok := p.manifests[key]
+	if !ok || info.Size != prev.Size || !info.ModTime.Equal(prev.ModTime) {
+		return nil, false
+	}
+	c, ok := p.index.get(key)
+	if !ok || c.interrupted(now) {
+		return nil, false
+	}
+	return c, true
+}
+
+func (p *pprof) loadCapture(ctx context.Context, key string) (*Capture, error) {
+	data, err := p.storage.GetBytes(ctx, path.Join(key, manifestName))
+	if err == nil {
+		c := &Capture{}
+		if err = json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("unmarshal manifest error: %w", err)
+		}
+		if now := time.Now(); c.interrupted(now) {
+			c.Status = CaptureStatusFailed
+			c.Error = "capture interrupted, the process running it exited before it finished"
+			c.Mtime = now.Unix()
+			if data, err = json.Marshal(c); err == nil {
+				err = p.storage.PutBytes(ctx, path.Join(key, manifestName), data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("write interrupted manifest error: %w", err)
+			}
+			elog.Warn("interrupted capture marked failed", zap.String("key", key))
+		}
+		return c, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	c, err := p.backfillCapture(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err = json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.storage.PutBytes(ctx, path.Join(key, manifestName), data); err != nil {
+		return nil, fmt.Errorf("write backfilled manifest error: %w", err)
+	}
+	elog.Info("capture manifest backfilled", zap.String("key", key))
+	return c, nil
+}
+
+// backfillCapture reconstructs the metadata of a legacy capture stored as <cluster>/<namespace>/<target>_<unixMilli>.
+func (p *pprof) backfillCapture(ctx context.Context, key string) (*Capture, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) == 2 {
+		parts = append([]string{""}, parts...)
+	}
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected capture key %s", key)
+	}
+	name := parts[2]
+	n := strings.LastIndex(name, "_")
+	if n <= 0 {
+		return nil, fmt.Errorf("unexpected capture dir name %s", name)
+	}
+	ctime := cast.ToInt64(name[n+1:]) / 1e3
+	c := &Capture{
+		Key:         key,
+		ClusterName: parts[0],
+		Namespace:   parts[1],
+		Kinds:       []string{},
+		TriggeredBy: TriggerManual,
+		Ctime:       ctime,
+		Mtime:       ctime,
+		Backfilled:  true,
+	}
+	switch parts[1] {
+	case customNamespace:
+		c.Mode = ProfileRunTypeAddr
+		c.Addr = name[:n]
+	case uploadNamespace:
+		c.Mode = ProfileRunTypeUpload
+		c.PodName = name[:n]
+	default:
+		c.Mode = ProfileRunTypePod
+		c.PodName = name[:n]
+	}
+	files, err := p.storage.List(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f, ".bin") {
+			c.Kinds = append(c.Kinds, strings.TrimSuffix(f, ".bin"))
+		}
+		if data, err := p.storage.GetBytes(ctx, path.Join(key, f)); err == nil {
+			c.Size += int64(len(data))
+		}
+	}
+	sort.Strings(c.Kinds)
+	if len(c.Kinds) == 0 {
+		c.Status = CaptureStatusFailed
+	} else {
+		c.Status = CaptureStatusSuccess
+	}
+	return c, nil
+}
+
+// isReservedKey reports whether a top level storage entry belongs to goprobe itself rather than a cluster.
+func isReservedKey(name string) bool {
+	return strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")
+}
+
+// validClusterName reports whether name can be the first segment of a capture key, reserved names
+// and the directories of the captures without a cluster are refused.
+func validClusterName(name string) bool {
+	return uploadNameRegexp.MatchString(name) && !isReservedKey(name) && name != customNamespace && name != uploadNamespace
+}
@@ -0,0 +1,159 @@
+package pprof
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"goprobe/pkg/storage"
+	"goprobe/pkg/storage/filesystem"
+)
+
+// newTestPprof returns a pprof storing captures in a temp dir.
+func newTestPprof(t *testing.T) *pprof {
+	t.Helper()
+	return &pprof{storage: filesystem.NewFilesystemClient(t.TempDir()), index: newCaptureIndex()}
+}
+
+func TestLoadIndexBackfill(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	for _, key := range []string{
+		"saas/default/api-7d9f_1650000000000/heap.bin",
+		"saas/default/api-7d9f_1650000000000/profile.bin",
+		"saas/custom/10.0.0.1:6060_1650000001000/goroutine.bin",
+		"custom/10.0.0.2:6060_1649999999000/heap.bin",
+	} {
+		if err := p.storage.PutBytes(ctx, key, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest, _ := json.Marshal(&Capture{Key: "saas/default/web_1650000002000", PodName: "web", Status: CaptureStatusPartial, Ctime: 1650000002})
+	if err := p.storage.PutBytes(ctx, "saas/default/web_1650000002000/"+manifestName, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.loadIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	list := p.index.list(nil)
+	if len(list) != 4 {
+		t.Fatalf("index has %d captures, want 4", len(list))
+	}
+	if list[0].PodName != "web" || list[0].Status != CaptureStatusPartial || list[0].Backfilled {
+		t.Errorf("manifest capture = %+v", list[0])
+	}
+	addr := list[1]
+	if addr.Mode != ProfileRunTypeAddr || addr.Addr != "10.0.0.1:6060" || addr.Ctime != 1650000001 {
+		t.Errorf("backfilled addr capture = %+v", addr)
+	}
+	pod := list[2]
+	if pod.PodName != "api-7d9f" || len(pod.Kinds) != 2 || pod.Kinds[0] != "heap" || !pod.Backfilled {
+		t.Errorf("backfilled pod capture = %+v", pod)
+	}
+	if clusterless := list[3]; clusterless.Key != "custom/10.0.0.2:6060_1649999999000" || clusterless.ClusterName != "" || clusterless.Addr != "10.0.0.2:6060" {
+		t.Errorf("backfilled clusterless capture = %+v", clusterless)
+	}
+	// the backfilled manifest is persisted
+	if _, err := p.storage.GetBytes(ctx, "saas/default/api-7d9f_1650000000000/"+manifestName); err != nil {
+		t.Errorf("backfilled manifest not written: %v", err)
+	}
+}
+
+func TestLoadIndexInterrupted(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	now := time.Now()
+	for _, c := range []*Capture{
+		{Key: "saas/default/api_1650000000000", Seconds: 30, Status: CaptureStatusRunning, Ctime: 1650000000},
+		{Key: "saas/default/api_" + strconv.FormatInt(now.UnixMilli(), 10), Seconds: 30, Status: CaptureStatusRunning, Ctime: now.Unix()},
+	} {
+		manifest, _ := json.Marshal(c)
+		if err := p.storage.PutBytes(ctx, path.Join(c.Key, manifestName), manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.loadIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	list := p.index.list(nil)
+	if len(list) != 2 || list[0].Status != CaptureStatusRunning || list[1].Status != CaptureStatusFailed {
+		t.Fatalf("captures after load = %+v, want the fresh one running and the stale one failed", list)
+	}
+	loaded, err := p.loadCapture(ctx, list[1].Key)
+	if err != nil || loaded.Status != CaptureStatusFailed {
+		t.Errorf("failed status not persisted: %+v, %v", loaded, err)
+	}
+}
+
+// countingStorage records the manifests read through it.
+type countingStorage struct {
+	storage.Client
+	manifests []string
+}
+
+func (s *countingStorage) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	if path.Base(key) == manifestName {
+		s.manifests = append(s.manifests, path.Dir(key))
+	}
+	return s.Client.GetBytes(ctx, key)
+}
+
+func TestLoadIndexIncremental(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	counting := &countingStorage{Client: filesystem.NewFilesystemClient(dir)}
+	p := &pprof{storage: counting, index: newCaptureIndex()}
+	// manifests written within manifestTimeSlack are fetched again, age them
+	put := func(c *Capture, age time.Duration) {
+		t.Helper()
+		manifest, _ := json.Marshal(c)
+		if err := p.storage.PutBytes(ctx, path.Join(c.Key, manifestName), manifest); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(c.Key), manifestName), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func(want ...string) {
+		t.Helper()
+		counting.manifests = nil
+		if err := p.loadIndex(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(counting.manifests) != len(want) {
+			t.Fatalf("loadIndex() fetched %v, want %v", counting.manifests, want)
+		}
+		for i := range want {
+			if counting.manifests[i] != want[i] {
+				t.Fatalf("loadIndex() fetched %v, want %v", counting.manifests, want)
+			}
+		}
+	}
+	put(&Capture{Key: "saas/default/api_1650000000000", Status: CaptureStatusSuccess, Ctime: 1650000000, Mtime: 1650000000}, time.Hour)
+	put(&Capture{Key: "custom/10.0.0.1:6060_1650000001000", Status: CaptureStatusSuccess, Ctime: 1650000001, Mtime: 1650000001}, time.Hour)
+	load("custom/10.0.0.1:6060_1650000001000", "saas/default/api_1650000000000")
+	load()
+
+	put(&Capture{Key: "saas/default/api_1650000000000", Status: CaptureStatusPartial, Ctime: 1650000000, Mtime: 1650000010}, 30*time.Minute)
+	put(&Capture{Key: "saas/default/web_1650000002000", Status: CaptureStatusSuccess, Ctime: 1650000002, Mtime: 1650000002}, 30*time.Minute)
+	if err := p.storage.DeletePrefix(ctx, "custom/10.0.0.1:6060_1650000001000"); err != nil {
+		t.Fatal(err)
+	}
+	load("saas/default/api_1650000000000", "saas/default/web_1650000002000")
+	list := p.index.list(nil)
+	if len(list) != 2 || list[0].Key != "saas/default/web_1650000002000" || list[1].Status != CaptureStatusPartial {
+		t.Fatalf("captures after refresh = %+v, want web and the updated api", list)
+	}
+
+	// a manifest rewritten within the slack may keep its time, it is fetched until it ages
+	put(&Capture{Key: "saas/default/web_1650000002000", Status: CaptureStatusFailed, Ctime: 1650000002, Mtime: 1650000020}, 0)
+	load("saas/default/web_1650000002000")
+	load("saas/default/web_1650000002000")
+}
@@ -0,0 +1,173 @@
+package pprof
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"goprobe/pkg/storage"
+)
+
+const (
+	BundleFormatZip   = "zip"
+	BundleFormatTarGz = "tar.gz"
+)
+
+// checkKind rejects kinds c has no profile of, kinds end up in the storage keys of legacy captures.
+func checkKind(c *Capture, kind string) error {
+	if !containsString(c.Kinds, kind) {
+		return fmt.Errorf("capture %s has no %s profile: %w", c.Key, kind, storage.ErrNotFound)
+	}
+	return nil
+}
+
+// openRaw opens the raw pprof data of one profile kind of a capture, the caller closes it.
+func (p *pprof) openRaw(ctx context.Context, c *Capture, kind string) (io.ReadCloser, error) {
+	if err := checkKind(c, kind); err != nil {
+		return nil, err
+	}
+	return p.storage.Get(ctx, artifactKey(c, kind, blobRawName))
+}
+
+// GetRawProfile opens a raw profile for streaming and returns a file name usable with go tool pprof.
+func (p *pprof) GetRawProfile(key, kind string) (r io.ReadCloser, filename string, err error) {
+	c, err := p.GetCapture(key)
+	if err != nil {
+		return nil, "", err
+	}
+	r, err = p.openRaw(context.TODO(), c, kind)
+	if err != nil {
+		return nil, "", err
+	}
+	// profiles are gzipped protobuf
+	return r, fmt.Sprintf("%s_%s.pb.gz", captureFileBase(c), kind), nil
+}
+
+// BundleFilename is the download name of the bundle of capture key.
+func (p *pprof) BundleFilename(key, format string) (string, error) {
+	c, err := p.GetCapture(key)
+	if err != nil {
+		return "", err
+	}
+	if format != BundleFormatZip && format != BundleFormatTarGz {
+		return "", fmt.Errorf("bundle format (%s) isn't supported", format)
+	}
+	return captureFileBase(c) + "." + format, nil
+}
+
+// WriteBundle writes every artifact of a capture (raw profiles, rendered graphs and the manifest) as a zip or tar.gz archive.
+func (p *pprof) WriteBundle(w io.Writer, key, format string) error {
+	c, err := p.GetCapture(key)
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	files, err := p.captureArtifacts(ctx, c)
+	if err != nil {
+		return err
+	}
+	manifest, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := captureFileBase(c)
+	modTime := time.Unix(c.Ctime, 0)
+
+	switch format {
+	case BundleFormatZip:
+		zw := zip.NewWriter(w)
+		add := func(name string, data []byte) error {
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: path.Join(dir, name), Method: zip.Deflate, Modified: modTime})
+			if err != nil {
+				return err
+			}
+			_, err = f.Write(data)
+			return err
+		}
+		if err = p.addBundleFiles(ctx, files, manifest, add); err != nil {
+			return err
+		}
+		return zw.Close()
+	case BundleFormatTarGz:
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		add := func(name string, data []byte) error {
+			err := tw.WriteHeader(&tar.Header{Name: path.Join(dir, name), Mode: 0644, Size: int64(len(data)), ModTime: modTime})
+			if err != nil {
+				return err
+			}
+			_, err = tw.Write(data)
+			return err
+		}
+		if err = p.addBundleFiles(ctx, files, manifest, add); err != nil {
+			return err
+		}
+		if err = tw.Close(); err != nil {
+			return err
+		}
+		return gw.Close()
+	default:
+		return fmt.Errorf("bundle format (%s) isn't supported", format)
+	}
+}
+
+// artifact is a file of a capture: its name in the capture and its storage key.
+type artifact struct {
+	name string
+	key  string
+}
+
+// captureArtifacts lists the files of a capture sorted by name, blob files are named as in the legacy layout.
+func (p *pprof) captureArtifacts(ctx context.Context, c *Capture) ([]artifact, error) {
+	var files []artifact
+	names, err := p.storage.List(ctx, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if name != manifestName {
+			files = append(files, artifact{name: name, key: path.Join(c.Key, name)})
+		}
+	}
+	for kind, ref := range c.Profiles {
+		names, err = p.storage.List(ctx, blobDir(ref.Hash))
+		if err != nil {
+			return nil, fmt.Errorf("list %s blob error: %w", kind, err)
+		}
+		for _, name := range names {
+			files = append(files, artifact{name: legacyArtifactName(kind, name), key: path.Join(blobDir(ref.Hash), name)})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+func (p *pprof) addBundleFiles(ctx context.Context, files []artifact, manifest []byte, add func(name string, data []byte) error) error {
+	// the manifest is rendered from the index so it is always current
+	if err := add(manifestName, manifest); err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := p.storage.GetBytes(ctx, f.key)
+		if err != nil {
+			return fmt.Errorf("read %s error: %w", f.name, err)
+		}
+		if err = add(f.name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureFileBase names downloads after the capture directory, ':' from addrs isn't portable in file names.
+func captureFileBase(c *Capture) string {
+	return strings.NewReplacer(":", "_", "/", "_").Replace(path.Base(c.Key))
+}
@@ -0,0 +1,106 @@
+package pprof
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"goprobe/pkg/storage"
+	"goprobe/pkg/storage/filesystem"
+)
+
+func TestWriteBundle(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	c := &Capture{Key: "c/custom/10.0.0.1:6060_1650000000000", Addr: "10.0.0.1:6060", Status: CaptureStatusSuccess, Ctime: 1650000000}
+	for _, f := range []string{"heap.bin", "heap_flame.svg", "heap_profile.svg"} {
+		if err := p.storage.PutBytes(ctx, c.Key+"/"+f, []byte(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.saveCapture(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	want := "[10.0.0.1_6060_1650000000000/meta.json 10.0.0.1_6060_1650000000000/heap.bin 10.0.0.1_6060_1650000000000/heap_flame.svg 10.0.0.1_6060_1650000000000/heap_profile.svg]"
+
+	var buf bytes.Buffer
+	if err := p.WriteBundle(&buf, c.Key, BundleFormatZip); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if fmt.Sprint(names) != want {
+		t.Errorf("zip entries = %v, want %v", names, want)
+	}
+
+	buf.Reset()
+	if err = p.WriteBundle(&buf, c.Key, BundleFormatTarGz); err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	names = names[:0]
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+	}
+	if fmt.Sprint(names) != want {
+		t.Errorf("tar entries = %v, want %v", names, want)
+	}
+
+	if _, err = p.BundleFilename(c.Key, "rar"); err == nil {
+		t.Error("BundleFilename() accepted an unknown format")
+	}
+}
+
+func TestGetRawProfileUnknownKind(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret.bin"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &pprof{storage: filesystem.NewFilesystemClient(filepath.Join(dir, "root")), index: newCaptureIndex()}
+	c := &Capture{Key: "c/ns/legacy_1", Status: CaptureStatusSuccess, Kinds: []string{"heap"}}
+	if err := p.storage.PutBytes(ctx, c.Key+"/heap.bin", []byte("heap")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.saveCapture(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := p.GetRawProfile(c.Key, "heap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	for _, kind := range []string{"goroutine", "../../../../secret", "../legacy_2/heap"} {
+		if r, _, err := p.GetRawProfile(c.Key, kind); !errors.Is(err, storage.ErrNotFound) {
+			if err == nil {
+				r.Close()
+			}
+			t.Errorf("GetRawProfile(%s) error = %v, want ErrNotFound", kind, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+
+	"goprobe/pkg/dto"
+)
+
+const (
+	ExportFormatSpeedscope = "speedscope"
+	ExportFormatCollapsed  = "collapsed"
+	ExportFormatChrome     = "chrome"
+)
+
+// exportExtensions are the file name suffixes of the export formats.
+var exportExtensions = map[string]string{
+	ExportFormatSpeedscope: ".speedscope.json",
+	ExportFormatCollapsed:  ".folded",
+	ExportFormatChrome:     ".trace.json",
+}
+
+// Export converts a stored profile to a format of another viewer and returns a file name for it.
+func (p *pprof) Export(req dto.ReqExport) (data []byte, filename string, err error) {
+	ext, ok := exportExtensions[req.Format]
+	if !ok {
+		return nil, "", fmt.Errorf("export format (%s) isn't supported", req.Format)
+	}
+	c, err := p.GetCapture(req.Url)
+	if err != nil {
+		return nil, "", err
+	}
+	prof, err := p.parseProfile(context.TODO(), c, req.GoType)
+	if err != nil {
+		return nil, "", err
+	}
+	index, err := defaultSampleIndex(prof, req.SampleIndex)
+	if err != nil {
+		return nil, "", err
+	}
+	stacks := collapseStacks(prof, index)
+	name := fmt.Sprintf("%s_%s_%s", captureFileBase(c), req.GoType, prof.SampleType[index].Type)
+	switch req.Format {
+	case ExportFormatSpeedscope:
+		data, err = exportSpeedscope(name, prof.SampleType[index].Unit, stacks)
+	case ExportFormatCollapsed:
+		data = exportCollapsed(stacks)
+	case ExportFormatChrome:
+		data, err = exportChromeTrace(prof.SampleType[index].Unit, stacks)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, name + ext, nil
+}
+
+// stack is a call stack from the root to the leaf and the total value of its samples.
+type stack struct {
+	frames []frame
+	value  int64
+}
+
+type frame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"`
+}
+
+// collapseStacks merges the samples with identical stacks, sorted by stack.
+func collapseStacks(prof *profile.Profile, index int) []stack {
+	merged := make(map[string]*stack)
+	var keys []string
+	for _, s := range prof.Sample {
+		v := s.Value[index]
+		if v == 0 {
+			continue
+		}
+		var frames []frame
+		// locations and inlined lines are leaf first
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			lines := s.Location[i].Line
+			for j := len(lines) - 1; j >= 0; j-- {
+				f := frame{Name: "<unknown>", Line: lines[j].Line}
+				if fn := lines[j].Function; fn != nil {
+					f.Name, f.File = fn.Name, fn.Filename
+				}
+				frames = append(frames, f)
+			}
+		}
+		key := foldedStack(frames)
+		if st, ok := merged[key]; ok {
+			st.value += v
+			continue
+		}
+		merged[key] = &stack{frames: frames, value: v}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	stacks := make([]stack, 0, len(keys))
+	for _, key := range keys {
+		stacks = append(stacks, *merged[key])
+	}
+	return stacks
+}
+
+func foldedStack(frames []frame) string {
+	names := make([]string, 0, len(frames))
+	for _, f := range frames {
+		// ';' separates frames and ' ' the value in the folded format
+		names = append(names, strings.NewReplacer(";", ":", " ", "_").Replace(f.Name))
+	}
+	return strings.Join(names, ";")
+}
+
+// exportCollapsed writes Brendan Gregg's folded stacks, one "root;...;leaf value" line per stack.
+func exportCollapsed(stacks []stack) []byte {
+	var buf bytes.Buffer
+	for _, st := range stacks {
+		fmt.Fprintf(&buf, "%s %d\n", foldedStack(st.frames), st.value)
+	}
+	return buf.Bytes()
+}
+
+// exportSpeedscope writes a sampled profile of the speedscope file format, https://www.speedscope.app/file-format-schema.json.
+func exportSpeedscope(name, unit string, stacks []stack) ([]byte, error) {
+	type speedscopeProfile struct {
+		Type       string  `json:"type"`
+		Name       string  `json:"name"`
+		Unit       string  `json:"unit"`
+		StartValue int64   `json:"startValue"`
+		EndValue   int64   `json:"endValue"`
+		Samples    [][]int `json:"samples"`
+		Weights    []int64 `json:"weights"`
+	}
+	frameIndex := make(map[frame]int)
+	frames := make([]frame, 0)
+	sp := speedscopeProfile{Type: "sampled", Name: name, Unit: speedscopeUnit(unit), Samples: make([][]int, 0), Weights: make([]int64, 0)}
+	for _, st := range stacks {
+		sample := make([]int, 0, len(st.frames))
+		for _, f := range st.frames {
+			i, ok := frameIndex[f]
+			if !ok {
+				i = len(frames)
+				frameIndex[f] = i
+				frames = append(frames, f)
+			}
+			sample = append(sample, i)
+		}
+		sp.Samples = append(sp.Samples, sample)
+		sp.Weights = append(sp.Weights, st.value)
+		sp.EndValue += st.value
+	}
+	return json.Marshal(map[string]interface{}{
+		"$schema":            "https://www.speedscope.app/file-format-schema.json",
+		"name":               name,
+		"exporter":           "goprobe",
+		"activeProfileIndex": 0,
+		"shared":             map[string]interface{}{"frames": frames},
+		"profiles":           []speedscopeProfile{sp},
+	})
+}
+
+func speedscopeUnit(unit string) string {
+	switch unit {
+	case "nanoseconds", "microseconds", "milliseconds", "seconds", "bytes":
+		return unit
+	}
+	return "none"
+}
+
+// chromeEvent is a complete event of the Chrome trace event format.
+type chromeEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// exportChromeTrace lays the merged call tree out as a flame chart of complete events, usable in
+// chrome://tracing and Perfetto. Timestamps are microseconds: time units are converted, other units are used as is.
+func exportChromeTrace(unit string, stacks []stack) ([]byte, error) {
+	scale := 1.0
+	switch unit {
+	case "nanoseconds":
+		scale = 1e-3
+	case "milliseconds":
+		scale = 1e3
+	case "seconds":
+		scale = 1e6
+	}
+	type node struct {
+		frame    frame
+		value    int64
+		children map[string]*node
+		order    []string
+	}
+	root := &node{children: make(map[string]*node)}
+	for _, st := range stacks {
+		n := root
+		n.value += st.value
+		for _, f := range st.frames {
+			child, ok := n.children[f.Name]
+			if !ok {
+				child = &node{frame: f, children: make(map[string]*node)}
+				n.children[f.Name] = child
+				n.order = append(n.order, f.Name)
+			}
+			child.value += st.value
+			n = child
+		}
+	}
+	events := make([]chromeEvent, 0)
+	var walk func(n *node, ts int64)
+	walk = func(n *node, ts int64) {
+		for _, name := range n.order {
+			child := n.children[name]
+			event := chromeEvent{Name: name, Cat: "pprof", Ph: "X", Ts: float64(ts) * scale, Dur: float64(child.value) * scale, Pid: 1, Tid: 1}
+			if child.frame.File != "" {
+				event.Args = map[string]string{"file": fmt.Sprintf("%s:%d", child.frame.File, child.frame.Line)}
+			}
+			events = append(events, event)
+			walk(child, ts)
+			ts += child.value
+		}
+	}
+	walk(root, 0)
+	return json.Marshal(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+		"otherData":       map[string]string{"exporter": "goprobe", "unit": unit},
+	})
+}
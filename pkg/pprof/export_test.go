@@ -0,0 +1,72 @@
+package pprof
+
+import (
+	"encoding/json"
+	"testing"
+
+	"goprobe/pkg/dto"
+)
+
+func TestExport(t *testing.T) {
+	p := newTestPprof(t)
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+	export := func(format, sampleIndex string) ([]byte, string) {
+		t.Helper()
+		data, filename, err := p.Export(dto.ReqExport{Url: c.Key, GoType: "profile", Format: format, SampleIndex: sampleIndex})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, filename
+	}
+
+	data, filename := export(ExportFormatCollapsed, "samples")
+	if want := "main.main 1\nmain.main;main.work 3\n"; string(data) != want {
+		t.Errorf("collapsed = %q, want %q", data, want)
+	}
+	if filename != "pod_1_profile_samples.folded" {
+		t.Errorf("collapsed file name = %s", filename)
+	}
+
+	data, _ = export(ExportFormatSpeedscope, "")
+	var speedscope struct {
+		Shared struct {
+			Frames []frame `json:"frames"`
+		} `json:"shared"`
+		Profiles []struct {
+			Unit     string  `json:"unit"`
+			EndValue int64   `json:"endValue"`
+			Samples  [][]int `json:"samples"`
+			Weights  []int64 `json:"weights"`
+		} `json:"profiles"`
+	}
+	if err := json.Unmarshal(data, &speedscope); err != nil {
+		t.Fatal(err)
+	}
+	if len(speedscope.Shared.Frames) != 2 || len(speedscope.Profiles) != 1 {
+		t.Fatalf("speedscope = %s", data)
+	}
+	if sp := speedscope.Profiles[0]; sp.Unit != "nanoseconds" || sp.EndValue != 40000000 || len(sp.Samples) != 2 || sp.Weights[1] != 30000000 {
+		t.Errorf("speedscope profile = %+v", sp)
+	}
+
+	data, _ = export(ExportFormatChrome, "")
+	var trace struct {
+		TraceEvents []chromeEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(data, &trace); err != nil {
+		t.Fatal(err)
+	}
+	// main.main spans the whole profile, main.work is nested in it
+	want := []chromeEvent{
+		{Name: "main.main", Cat: "pprof", Ph: "X", Ts: 0, Dur: 40000, Pid: 1, Tid: 1, Args: map[string]string{"file": "main.go:10"}},
+		{Name: "main.work", Cat: "pprof", Ph: "X", Ts: 0, Dur: 30000, Pid: 1, Tid: 1, Args: map[string]string{"file": "main.go:20"}},
+	}
+	if len(trace.TraceEvents) != 2 || trace.TraceEvents[0].Name != want[0].Name || trace.TraceEvents[0].Dur != want[0].Dur ||
+		trace.TraceEvents[1].Name != want[1].Name || trace.TraceEvents[1].Dur != want[1].Dur {
+		t.Errorf("chrome trace = %+v, want %+v", trace.TraceEvents, want)
+	}
+
+	if _, _, err := p.Export(dto.ReqExport{Url: c.Key, GoType: "profile", Format: "svg"}); err == nil {
+		t.Error("unsupported format accepted")
+	}
+}
@@ -0,0 +1,120 @@
+package pprof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// graphFilters are the go tool pprof filters applied to a profile before rendering, all regexps.
+type graphFilters struct {
+	Focus    string // keep samples with a frame matching
+	Ignore   string // drop samples with a frame matching
+	Hide     string // drop matching frames from the stacks
+	Show     string // keep only matching frames in the stacks
+	TagFocus string // keep samples with a label matching, key=regexp or regexp matched against any label value
+	Prune    string // drop the frames below the lowest frame matching, like -prune_from
+}
+
+func (f graphFilters) empty() bool {
+	return f == graphFilters{}
+}
+
+// key identifies the filter set in artifact names.
+func (f graphFilters) key() string {
+	if f.empty() {
+		return ""
+	}
+	h := sha256.New()
+	for _, v := range []string{f.Focus, f.Ignore, f.Hide, f.Show, f.TagFocus, f.Prune} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (f graphFilters) validate() error {
+	for name, v := range map[string]string{"focus": f.Focus, "ignore": f.Ignore, "hide": f.Hide, "show": f.Show, "prune": f.Prune} {
+		if _, err := compileFilter(v); err != nil {
+			return fmt.Errorf("invalid %s filter: %w", name, err)
+		}
+	}
+	if _, _, err := parseTagFocus(f.TagFocus); err != nil {
+		return fmt.Errorf("invalid tagfocus filter: %w", err)
+	}
+	return nil
+}
+
+func compileFilter(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// parseTagFocus parses key=regexp, or a regexp matched against the values of every label.
+func parseTagFocus(expr string) (key string, rx *regexp.Regexp, err error) {
+	if expr == "" {
+		return "", nil, nil
+	}
+	if n := strings.Index(expr, "="); n > 0 {
+		key, expr = expr[:n], expr[n+1:]
+	}
+	rx, err = regexp.Compile(expr)
+	return key, rx, err
+}
+
+// apply filters raw and returns the filtered profile, an error if nothing is left to render.
+func (f graphFilters) apply(raw []byte) ([]byte, error) {
+	if f.empty() {
+		return raw, nil
+	}
+	prof, err := profile.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse profile error: %w", err)
+	}
+	focus, _ := compileFilter(f.Focus)
+	ignore, _ := compileFilter(f.Ignore)
+	hide, _ := compileFilter(f.Hide)
+	show, _ := compileFilter(f.Show)
+	prune, _ := compileFilter(f.Prune)
+
+	if prune != nil {
+		prof.PruneFrom(prune)
+	}
+	fm, _, _, _ := prof.FilterSamplesByName(focus, ignore, hide, show)
+	if focus != nil && !fm {
+		return nil, fmt.Errorf("focus %q matched no samples", f.Focus)
+	}
+	if key, rx, _ := parseTagFocus(f.TagFocus); rx != nil {
+		fm, _ = prof.FilterSamplesByTag(func(s *profile.Sample) bool {
+			for k, values := range s.Label {
+				if key != "" && k != key {
+					continue
+				}
+				for _, v := range values {
+					if rx.MatchString(v) {
+						return true
+					}
+				}
+			}
+			return false
+		}, nil)
+		if !fm {
+			return nil, fmt.Errorf("tagfocus %q matched no samples", f.TagFocus)
+		}
+	}
+	if len(prof.Sample) == 0 {
+		return nil, fmt.Errorf("no samples left after filtering")
+	}
+	var buf bytes.Buffer
+	if err = prof.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,56 @@
+package pprof
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestGraphFiltersApply(t *testing.T) {
+	raw := testProfile(t)
+	tests := []struct {
+		name    string
+		filters graphFilters
+		samples int
+		wantErr bool
+	}{
+		{"none", graphFilters{}, 2, false},
+		{"focus", graphFilters{Focus: "main\\.work"}, 1, false},
+		{"ignore", graphFilters{Ignore: "main\\.work"}, 1, false},
+		{"hide", graphFilters{Hide: "main\\.main"}, 1, false},
+		{"focus without match", graphFilters{Focus: "runtime\\."}, 0, true},
+		{"tagfocus without labels", graphFilters{TagFocus: "method=Get"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.filters.apply(raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			prof, err := profile.Parse(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(prof.Sample) != tt.samples {
+				t.Errorf("apply() left %d samples, want %d", len(prof.Sample), tt.samples)
+			}
+		})
+	}
+}
+
+func TestGraphFiltersKey(t *testing.T) {
+	if (graphFilters{}).key() != "" {
+		t.Error("empty filters have a key")
+	}
+	a, b := graphFilters{Focus: "a"}, graphFilters{Ignore: "a"}
+	if a.key() == b.key() || a.key() != (graphFilters{Focus: "a"}).key() {
+		t.Errorf("keys of %+v and %+v: %s %s", a, b, a.key(), b.key())
+	}
+	if err := (graphFilters{Focus: "("}).validate(); err == nil {
+		t.Error("invalid regexp accepted")
+	}
+}
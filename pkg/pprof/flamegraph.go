@@ -0,0 +1,68 @@
+package pprof
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+
+	"github.com/google/pprof/profile"
+)
+
+//go:embed flamegraph.html
+var flameGraphHTML string
+
+var flameGraphTemplate = template.Must(template.New("flamegraph").Parse(flameGraphHTML))
+
+// flameNode is a frame of the merged call tree, short json names keep big pages small.
+type flameNode struct {
+	Name     string       `json:"n"`
+	Value    int64        `json:"v"`
+	Children []*flameNode `json:"c,omitempty"`
+
+	index map[string]*flameNode
+}
+
+// flameTree merges the stacks of a sample type into a call tree, children in alphabetical order like flamegraph.pl.
+func flameTree(prof *profile.Profile, index int) *flameNode {
+	root := &flameNode{Name: "root", index: make(map[string]*flameNode)}
+	for _, st := range collapseStacks(prof, index) {
+		node := root
+		node.Value += st.value
+		for _, f := range st.frames {
+			child, ok := node.index[f.Name]
+			if !ok {
+				child = &flameNode{Name: f.Name, index: make(map[string]*flameNode)}
+				node.index[f.Name] = child
+				node.Children = append(node.Children, child)
+			}
+			child.Value += st.value
+			node = child
+		}
+	}
+	return root
+}
+
+// renderFlameGraphHTML renders a self-contained interactive flame graph page.
+func renderFlameGraphHTML(title string, raw []byte, sampleIndex string) ([]byte, error) {
+	prof, err := profile.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse profile error: %w", err)
+	}
+	index, err := defaultSampleIndex(prof, sampleIndex)
+	if err != nil {
+		return nil, err
+	}
+	root := flameTree(prof, index)
+	var buf bytes.Buffer
+	err = flameGraphTemplate.Execute(&buf, map[string]interface{}{
+		"Title": fmt.Sprintf("%s %s", title, prof.SampleType[index].Type),
+		"Unit":  prof.SampleType[index].Unit,
+		"Total": root.Value,
+		"Root":  root,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
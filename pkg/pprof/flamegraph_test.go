@@ -0,0 +1,35 @@
+package pprof
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"goprobe/pkg/dto"
+)
+
+func TestFlameGraphHTML(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+
+	data, err := p.FindGraphData(dto.ReqPprofGraph{Url: c.Key, GoType: "profile", SvgType: "html", SampleIndex: "samples"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	for _, want := range []string{
+		`{"n":"root","v":4,"c":[{"n":"main.main","v":4,"c":[{"n":"main.work","v":3}]}]}`,
+		"<title>c/ns/pod_1/profile samples</title>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page doesn't contain %s", want)
+		}
+	}
+	// the page is cached as a variant of the blob
+	key := path.Join(blobDir(c.Profiles["profile"].Hash), graphVariant{sampleIndex: "samples"}.name("html"))
+	if _, err = p.storage.Stat(ctx, key); err != nil {
+		t.Errorf("rendered page not cached at %s: %v", key, err)
+	}
+}
@@ -0,0 +1,96 @@
+package pprof
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"goprobe/pkg/dto"
+)
+
+const (
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize     = 200
+)
+
+// History is one page of captures.
+type History struct {
+	Total    int        `json:"total"`
+	Page     int        `json:"page"`
+	PageSize int        `json:"pageSize"`
+	List     []*Capture `json:"list"`
+}
+
+// ListHistory pages through the captures of every cluster, including addr mode and uploaded captures.
+func (p *pprof) ListHistory(req dto.ReqListHistory) (*History, error) {
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = defaultHistoryPageSize
+	}
+	if req.PageSize > maxHistoryPageSize {
+		req.PageSize = maxHistoryPageSize
+	}
+	switch req.Sort {
+	case "", "desc", "asc":
+	default:
+		return nil, fmt.Errorf("sort (%s) isn't supported", req.Sort)
+	}
+	selector, err := ParseLabels(req.Labels)
+	if err != nil {
+		return nil, err
+	}
+	workloadKind, workloadName := "", req.Workload
+	if n := strings.Index(req.Workload, "/"); n >= 0 {
+		workloadKind, workloadName = req.Workload[:n], req.Workload[n+1:]
+	}
+
+	captures := p.index.list(func(c *Capture) bool {
+		switch {
+		case req.ClusterName != "" && c.ClusterName != req.ClusterName,
+			req.Namespace != "" && c.Namespace != req.Namespace,
+			req.PodName != "" && !strings.HasPrefix(c.PodName, req.PodName),
+			req.Addr != "" && !strings.HasPrefix(c.Addr, req.Addr),
+			req.Start > 0 && c.Ctime < req.Start,
+			req.End > 0 && c.Ctime >= req.End,
+			req.Status != "" && c.Status != req.Status:
+			return false
+		}
+		if req.TriggeredBy != "" && c.TriggeredBy != req.TriggeredBy && !(c.TriggeredBy == "" && req.TriggeredBy == TriggerManual) {
+			return false
+		}
+		if req.Workload != "" {
+			if c.Workload == nil || c.Workload.Name != workloadName || (workloadKind != "" && c.Workload.Kind != workloadKind) {
+				return false
+			}
+		}
+		if req.Kind != "" && !containsString(c.Kinds, req.Kind) {
+			return false
+		}
+		return matchLabels(c.Labels, selector)
+	})
+	if req.Sort == "asc" {
+		sort.SliceStable(captures, func(i, j int) bool { return captures[i].Ctime < captures[j].Ctime })
+	}
+
+	history := &History{Total: len(captures), Page: req.Page, PageSize: req.PageSize, List: make([]*Capture, 0)}
+	start := (req.Page - 1) * req.PageSize
+	if start < len(captures) {
+		end := start + req.PageSize
+		if end > len(captures) {
+			end = len(captures)
+		}
+		history.List = captures[start:end]
+	}
+	return history, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package pprof
+
+import (
+	"reflect"
+	"testing"
+
+	"goprobe/pkg/dto"
+	"goprobe/pkg/kube"
+)
+
+func TestListHistory(t *testing.T) {
+	p := &pprof{index: newCaptureIndex()}
+	api := &kube.Workload{Kind: "Deployment", Name: "api"}
+	for _, c := range []*Capture{
+		{Key: "c/ns/api-1_1000", ClusterName: "c", Namespace: "ns", PodName: "api-1", Workload: api, TriggeredBy: TriggerManual, Kinds: []string{"heap"}, Status: CaptureStatusSuccess, Ctime: 1},
+		{Key: "c/ns/api-2_2000", ClusterName: "c", Namespace: "ns", PodName: "api-2", Workload: api, TriggeredBy: TriggerScheduled, Kinds: []string{"heap", "profile"}, Status: CaptureStatusSuccess, Ctime: 2},
+		{Key: "c/ns/worker-1_3000", ClusterName: "c", Namespace: "ns", PodName: "worker-1", TriggeredBy: TriggerThreshold, Kinds: []string{"profile"}, Status: CaptureStatusFailed, Ctime: 3},
+		{Key: "custom/10.0.0.1:6060_4000", Namespace: customNamespace, Addr: "10.0.0.1:6060", Kinds: []string{"heap"}, Status: CaptureStatusSuccess, Ctime: 4},
+	} {
+		p.index.put(c)
+	}
+	keys := func(h *History) (out []string) {
+		for _, c := range h.List {
+			out = append(out, c.Key)
+		}
+		return
+	}
+	tests := []struct {
+		name  string
+		req   dto.ReqListHistory
+		total int
+		want  []string
+	}{
+		{"all newest first", dto.ReqListHistory{}, 4, []string{"custom/10.0.0.1:6060_4000", "c/ns/worker-1_3000", "c/ns/api-2_2000", "c/ns/api-1_1000"}},
+		{"page", dto.ReqListHistory{Page: 2, PageSize: 3}, 4, []string{"c/ns/api-1_1000"}},
+		{"oldest first", dto.ReqListHistory{Sort: "asc", PageSize: 1}, 4, []string{"c/ns/api-1_1000"}},
+		{"workload", dto.ReqListHistory{Workload: "Deployment/api"}, 2, []string{"c/ns/api-2_2000", "c/ns/api-1_1000"}},
+		{"kind", dto.ReqListHistory{Kind: "profile"}, 2, []string{"c/ns/worker-1_3000", "c/ns/api-2_2000"}},
+		{"addr", dto.ReqListHistory{Addr: "10.0.0."}, 1, []string{"custom/10.0.0.1:6060_4000"}},
+		{"time range", dto.ReqListHistory{Start: 2, End: 4}, 2, []string{"c/ns/worker-1_3000", "c/ns/api-2_2000"}},
+		{"triggered by", dto.ReqListHistory{TriggeredBy: TriggerThreshold}, 1, []string{"c/ns/worker-1_3000"}},
+		{"legacy captures are manual", dto.ReqListHistory{TriggeredBy: TriggerManual}, 2, []string{"custom/10.0.0.1:6060_4000", "c/ns/api-1_1000"}},
+		{"status and pod prefix", dto.ReqListHistory{PodName: "api", Status: CaptureStatusSuccess}, 2, []string{"c/ns/api-2_2000", "c/ns/api-1_1000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := p.ListHistory(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := keys(h); h.Total != tt.total || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListHistory() = %d %v, want %d %v", h.Total, got, tt.total, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/gotomicro/ego/core/econf"
+
+	"goprobe/pkg/storage"
+)
+
+const (
+	ImageFormatSvg = "svg"
+	ImageFormatPng = "png"
+	ImageFormatPdf = "pdf"
+
+	// maxImageScale caps width×dpi, rsvg-convert has no pixel limit. Width 0 counts as the widest
+	// allowed and dpi 0 as 96.
+	maxImageScale = 1600 * 300
+)
+
+// imageWidths and imageDpis are the sizes graphs are converted to, every pair is stored per graph.
+var (
+	imageWidths = []int{0, 800, 1200, 1600, 2400, 3200}
+	imageDpis   = []int{0, 72, 96, 150, 300}
+)
+
+// imageOptions converts an svg graph to png or pdf, the zero value keeps the svg.
+type imageOptions struct {
+	format string
+	width  int // pixels, 0 keeps the width of the svg
+	dpi    int // 0 is the default of rsvg-convert, 96
+}
+
+func (o imageOptions) validate() error {
+	switch o.format {
+	case "", ImageFormatSvg, ImageFormatPng, ImageFormatPdf:
+	default:
+		return fmt.Errorf("image format (%s) isn't supported", o.format)
+	}
+	if !containsInt(imageWidths, o.width) {
+		return fmt.Errorf("width must be one of %v", imageWidths)
+	}
+	if !containsInt(imageDpis, o.dpi) {
+		return fmt.Errorf("dpi must be one of %v", imageDpis)
+	}
+	width, dpi := o.width, o.dpi
+	if width == 0 {
+		width = imageWidths[len(imageWidths)-1]
+	}
+	if dpi == 0 {
+		dpi = 96
+	}
+	if width*dpi > maxImageScale {
+		return fmt.Errorf("width×dpi must not exceed %d, width 0 counting as %d", maxImageScale, imageWidths[len(imageWidths)-1])
+	}
+	return nil
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// svg reports whether the graph is served as rendered.
+func (o imageOptions) svg() bool {
+	return o.format == "" || o.format == ImageFormatSvg
+}
+
+// name is the artifact name of the image of the svg named svgName, e.g. profile_w1600_d150.png.
+func (o imageOptions) name(svgName string) string {
+	name := strings.TrimSuffix(svgName, ".svg")
+	if o.width > 0 {
+		name += "_w" + strconv.Itoa(o.width)
+	}
+	if o.dpi > 0 {
+		name += "_d" + strconv.Itoa(o.dpi)
+	}
+	return name + "." + o.format
+}
+
+// image returns a graph converted to png or pdf, converting and storing it on first request.
+func (p *pprof) image(ctx context.Context, c *Capture, kind, svgType string, v graphVariant, o imageOptions) ([]byte, error) {
+	if err := v.validate(); err != nil {
+		return nil, err
+	}
+	if err := checkKind(c, kind); err != nil {
+		return nil, err
+	}
+	key := artifactKey(c, kind, o.name(v.name(svgType)))
+	convert := func() ([]byte, error) {
+		svg, err := p.graph(ctx, c, kind, svgType, v)
+		if err != nil {
+			return nil, err
+		}
+		if len(svg) == 0 {
+			return nil, fmt.Errorf("%s 图为空, 没有可转换的内容", svgType)
+		}
+		return convertSvg(svg, o)
+	}
+	if !v.stored() {
+		return p.renderCached(key, convert)
+	}
+	data, err := p.storage.GetBytes(ctx, key)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return data, err
+	}
+	return p.render(key, func() ([]byte, error) {
+		data, err := convert()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.storeRender(ctx, c, kind, key, data); err != nil {
+			return nil, fmt.Errorf("保存 %s 图失败: %w", o.format, err)
+		}
+		return data, nil
+	})
+}
+
+// convertSvg converts svg with rsvg-convert, render.rsvgConvert overrides its path.
+func convertSvg(svg []byte, o imageOptions) ([]byte, error) {
+	bin := econf.GetString("render.rsvgConvert")
+	if bin == "" {
+		bin = "rsvg-convert"
+	}
+	args := []string{"-f", o.format}
+	if o.width > 0 {
+		args = append(args, "-w", strconv.Itoa(o.width), "-a")
+	}
+	if o.dpi > 0 {
+		dpi := strconv.Itoa(o.dpi)
+		args = append(args, "-d", dpi, "-p", dpi)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = bytes.NewReader(svg)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("转换 %s 失败: %v %s", o.format, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package pprof
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/gotomicro/ego/core/econf"
+
+	"goprobe/pkg/dto"
+)
+
+func TestImageOptions(t *testing.T) {
+	for _, tc := range []struct {
+		o    imageOptions
+		want string
+	}{
+		{imageOptions{format: ImageFormatPng}, "profile.png"},
+		{imageOptions{format: ImageFormatPdf, width: 1600}, "profile_w1600.pdf"},
+		{imageOptions{format: ImageFormatPng, width: 800, dpi: 150}, "profile_w800_d150.png"},
+	} {
+		if got := tc.o.name("profile.svg"); got != tc.want {
+			t.Errorf("%+v name = %s, want %s", tc.o, got, tc.want)
+		}
+	}
+	for _, o := range []imageOptions{
+		{format: ImageFormatPng, width: 3200},
+		{format: ImageFormatPng, width: 1600, dpi: 300},
+		{format: ImageFormatPdf, dpi: 150},
+	} {
+		if err := o.validate(); err != nil {
+			t.Errorf("%+v should be valid: %v", o, err)
+		}
+	}
+	for _, o := range []imageOptions{
+		{format: "gif"},
+		{format: ImageFormatPng, width: -1},
+		{format: ImageFormatPng, width: 1000},
+		{format: ImageFormatPng, dpi: 200},
+		{format: ImageFormatPng, width: 3200, dpi: 300},
+		{format: ImageFormatPng, dpi: 300},
+	} {
+		if err := o.validate(); err == nil {
+			t.Errorf("%+v should be invalid", o)
+		}
+	}
+}
+
+func TestGraphImage(t *testing.T) {
+	// the fake converter echoes its arguments and input
+	bin := filepath.Join(t.TempDir(), "rsvg-convert")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\necho \"$@\"\ncat\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	econf.Set("render.rsvgConvert", bin)
+	defer econf.Set("render.rsvgConvert", "")
+
+	ctx := context.Background()
+	p := newTestPprof(t)
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+	dir := blobDir(c.Profiles["profile"].Hash)
+	if err := p.storage.PutBytes(ctx, path.Join(dir, "profile.svg"), []byte("<svg/>")); err != nil {
+		t.Fatal(err)
+	}
+
+	req := dto.ReqPprofGraph{Url: c.Key, GoType: "profile", SvgType: "profile", Format: ImageFormatPng, Width: 800, Dpi: 150}
+	data, err := p.FindGraphData(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-f png -w 800 -a -d 150 -p 150\n<svg/>"; string(data) != want {
+		t.Errorf("converted %q, want %q", data, want)
+	}
+	cached, err := p.storage.GetBytes(ctx, path.Join(dir, "profile_w800_d150.png"))
+	if err != nil || string(cached) != string(data) {
+		t.Errorf("cached image %q, %v", cached, err)
+	}
+
+	req.SvgType, req.Format = "html", ImageFormatPdf
+	if _, err = p.FindGraphData(req); err == nil {
+		t.Error("html graph shouldn't convert to pdf")
+	}
+}
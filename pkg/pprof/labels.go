@@ -0,0 +1,113 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"goprobe/pkg/dto"
+)
+
+const (
+	maxLabels          = 32
+	maxLabelValueBytes = 256
+	maxNoteBytes       = 4096
+)
+
+var labelKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._/-]{0,61}[a-zA-Z0-9])?$`)
+
+// ParseLabels parses labels written as k1=v1,k2=v2.
+func ParseLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid label %q, want key=value", pair)
+		}
+		labels[key] = strings.TrimSpace(kv[1])
+	}
+	return labels, validateLabels(labels)
+}
+
+// validateLabels keeps label keys usable in selectors, e.g. release, incident or app.kubernetes.io/version.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("too many labels, at most %d are allowed", maxLabels)
+	}
+	for k, v := range labels {
+		if !labelKeyRegexp.MatchString(k) {
+			return fmt.Errorf("invalid label key %q, letters, digits and ._/- are allowed, at most 63 characters", k)
+		}
+		if len(v) > maxLabelValueBytes || strings.Contains(v, ",") {
+			return fmt.Errorf("invalid value of label %s, at most %d bytes without ','", k, maxLabelValueBytes)
+		}
+	}
+	return nil
+}
+
+// matchLabels reports whether labels has every key/value of selector.
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateCapture replaces the labels and/or the note of a finished capture.
+func (p *pprof) UpdateCapture(req dto.ReqUpdateCapture, operator string) (*Capture, error) {
+	if req.Labels == nil && req.Note == nil {
+		return nil, fmt.Errorf("nothing to update, labels or note is required")
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		return nil, err
+	}
+	if req.Note != nil && len(*req.Note) > maxNoteBytes {
+		return nil, fmt.Errorf("note is too long, at most %d bytes", maxNoteBytes)
+	}
+	ctx := context.TODO()
+	c, err := p.updateCapture(ctx, req.Url, func(c *Capture) error {
+		if c.Status == CaptureStatusRunning {
+			return fmt.Errorf("capture %s is still running", c.Key)
+		}
+		if req.Labels != nil {
+			c.Labels = req.Labels
+		}
+		if req.Note != nil {
+			c.Note = *req.Note
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.audit(ctx, AuditRecord{Operator: operator, Action: AuditActionUpdate, Keys: []string{c.Key}, Detail: "labels/note"})
+	return c, nil
+}
+
+// updateCapture applies fn to the manifest of capture key and saves it, updates are serialized
+// so concurrent edits of different fields don't overwrite each other.
+func (p *pprof) updateCapture(ctx context.Context, key string, fn func(c *Capture) error) (*Capture, error) {
+	p.updateMu.Lock()
+	defer p.updateMu.Unlock()
+	c, err := p.GetCapture(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = fn(c); err != nil {
+		return nil, err
+	}
+	c.Mtime = time.Now().Unix()
+	if err = p.saveCapture(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
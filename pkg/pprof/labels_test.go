@@ -0,0 +1,59 @@
+package pprof
+
+import (
+	"context"
+	"testing"
+
+	"goprobe/pkg/dto"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels(" env=prod, version = v1.2 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 || labels["env"] != "prod" || labels["version"] != "v1.2" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	for _, s := range []string{"env", "=prod", "bad key=1", "-env=1"} {
+		if _, err = ParseLabels(s); err == nil {
+			t.Errorf("ParseLabels(%q) accepted", s)
+		}
+	}
+}
+
+func TestUpdateCapture(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	c := &Capture{Key: "c/ns/pod_1", Status: CaptureStatusSuccess, Labels: map[string]string{"env": "canary"}}
+	if err := p.saveCapture(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	note := "leak after deploy"
+	if _, err := p.UpdateCapture(dto.ReqUpdateCapture{Url: c.Key, Note: &note}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.UpdateCapture(dto.ReqUpdateCapture{Url: c.Key, Labels: map[string]string{"release": "v1.42", "incident": "INC-123"}}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.UpdateCapture(dto.ReqUpdateCapture{Url: c.Key, Labels: map[string]string{"bad key": "x"}}, "alice"); err == nil {
+		t.Error("invalid label key accepted")
+	}
+
+	// the manifest is persisted, reload it from storage
+	reloaded, err := p.loadCapture(ctx, c.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Note != note || len(reloaded.Labels) != 2 || reloaded.Labels["release"] != "v1.42" {
+		t.Errorf("reloaded capture note %q labels %v", reloaded.Note, reloaded.Labels)
+	}
+
+	h, err := p.ListHistory(dto.ReqListHistory{Labels: "release=v1.42,incident=INC-123"})
+	if err != nil || h.Total != 1 {
+		t.Errorf("ListHistory() by labels = %+v, %v, want the capture", h, err)
+	}
+	if h, _ = p.ListHistory(dto.ReqListHistory{Labels: "release=v1.41"}); h.Total != 0 {
+		t.Errorf("ListHistory() by other release = %d captures, want none", h.Total)
+	}
+}
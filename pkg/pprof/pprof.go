@@ -8,54 +8,127 @@ import (
 	"os"
 	"os/exec"
 	"path"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/pprof/profile"
 	"github.com/gotomicro/ego/core/econf"
 	"github.com/gotomicro/ego/core/elog"
 	"github.com/pkg/errors"
-	"github.com/spf13/cast"
 	torchPprof "github.com/uber-archive/go-torch/pprof"
 	"github.com/uber-archive/go-torch/renderer"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+	corev1 "k8s.io/api/core/v1"
 
 	"goprobe/pkg/dto"
 	"goprobe/pkg/kube"
 	"goprobe/pkg/storage"
 	"goprobe/pkg/storage/filesystem"
+	"goprobe/pkg/storage/s3"
 )
 
 var profileTypes = []string{"block", "goroutine", "heap", "profile"}
 
 const (
-	ProfileRunTypePod  = "pod"
-	ProfileRunTypeAddr = "ip"
+	ProfileRunTypePod    = "pod"
+	ProfileRunTypeAddr   = "ip"
+	ProfileRunTypeUpload = "upload"
+)
+
+const (
+	// customNamespace holds the captures of addr mode
+	customNamespace = "custom"
+	// uploadNamespace holds the profiles uploaded from outside
+	uploadNamespace = "upload"
 )
 
 var Pprof *pprof
 
 func Init() error {
-	// check path
-	basePath := econf.GetString("storage.filesystem.basePath")
-	err := os.MkdirAll(basePath, 0755)
+	storageClient, err := newStorage()
 	if err != nil {
-		return fmt.Errorf("init pprof check dir failed: %w", err)
+		return err
+	}
+	if econf.GetBool("storage.compress") {
+		storageClient = storage.NewCompressed(storageClient, storage.DefaultCompressSuffixes)
 	}
 	Pprof = &pprof{
-		storage: filesystem.NewFilesystemClient(basePath),
+		storage: storageClient,
+		index:   newCaptureIndex(),
+		webUIs:  newWebUIs(econf.GetInt("ui.cacheSize")),
+		graphs:  newGraphCache(econf.GetInt("render.cacheBytes")),
 	}
 	err = Pprof.checkEnv()
 	if err != nil {
 		return fmt.Errorf("init pprof check env failed: %w", err)
 	}
+	err = Pprof.loadIndex(context.Background())
+	if err != nil {
+		return fmt.Errorf("init pprof load capture index failed: %w", err)
+	}
+	// other replicas sharing the storage write captures too
+	if interval := econf.GetDuration("storage.indexRefreshInterval"); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				if err := Pprof.loadIndex(context.Background()); err != nil {
+					elog.Warn("refresh capture index error", zap.Error(err))
+				}
+			}
+		}()
+	}
 	return nil
 }
 
+// newStorage builds the storage backend selected by storage.type, filesystem by default.
+func newStorage() (storage.Client, error) {
+	switch storageType := econf.GetString("storage.type"); storageType {
+	case "", "filesystem":
+		// check path
+		basePath := econf.GetString("storage.filesystem.basePath")
+		err := os.MkdirAll(basePath, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("init pprof check dir failed: %w", err)
+		}
+		return filesystem.NewFilesystemClient(basePath), nil
+	case "s3":
+		client, err := s3.NewS3Client(s3.Config{
+			Endpoint:  econf.GetString("storage.s3.endpoint"),
+			AccessKey: econf.GetString("storage.s3.accessKey"),
+			SecretKey: econf.GetString("storage.s3.secretKey"),
+			Bucket:    econf.GetString("storage.s3.bucket"),
+			Region:    econf.GetString("storage.s3.region"),
+			Prefix:    econf.GetString("storage.s3.prefix"),
+			UseSSL:    econf.GetBool("storage.s3.useSSL"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("init pprof s3 storage failed: %w", err)
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("storage type (%s) isn't supported", storageType)
+	}
+}
+
 type pprof struct {
 	storage storage.Client
+	index   *captureIndex
+	// loadMu serializes index loads, manifests is the info of the manifests seen by the previous one
+	loadMu    sync.Mutex
+	manifests map[string]storage.ObjectInfo
+	blobs     blobRefs
+	// updateMu serializes read-modify-write updates of capture manifests
+	updateMu sync.Mutex
+	// sizeMu serializes the size updates of blobs shared by several captures
+	sizeMu sync.Mutex
+	webUIs *webUIs
+	// renders de-duplicates concurrent renderings of the same graph, keyed by storage key
+	renders singleflight.Group
+	// graphs keeps the filtered graphs, which aren't stored
+	graphs *graphCache
 }
 
 type PprofInfo struct {
@@ -65,14 +138,30 @@ type PprofInfo struct {
 
 // GeneratePprof 生成PProf图
 func (p *pprof) GeneratePprof(reqRunProfile dto.ReqRunProfile) (list []PprofInfo, err error) {
-	list = make([]PprofInfo, 0)
+	var (
+		fetch    fetchFunc
+		workload *kube.Workload
+	)
+	now := time.Now()
+	labels, err := ParseLabels(reqRunProfile.Labels)
+	if err != nil {
+		return
+	}
+	switch reqRunProfile.TriggeredBy {
+	case "":
+		reqRunProfile.TriggeredBy = TriggerManual
+	case TriggerManual, TriggerScheduled, TriggerThreshold:
+	default:
+		err = fmt.Errorf("triggeredBy (%s) isn't supported", reqRunProfile.TriggeredBy)
+		return
+	}
 	switch reqRunProfile.Mode {
 	case ProfileRunTypePod:
 		if reqRunProfile.PodName == "" || reqRunProfile.ClusterName == "" {
 			err = fmt.Errorf("pod_name or cluster_id cannot be empty")
 			return
 		}
-		reqRunProfile.UniqueKey = fmt.Sprintf("%s/%s/%s_%d", reqRunProfile.ClusterName, reqRunProfile.Namespace, reqRunProfile.PodName, time.Now().UnixMilli())
+		reqRunProfile.UniqueKey = fmt.Sprintf("%s/%s/%s_%d", reqRunProfile.ClusterName, reqRunProfile.Namespace, reqRunProfile.PodName, now.UnixMilli())
 		if reqRunProfile.Port == 0 {
 			err = fmt.Errorf("治理端口未设置，请设置治理端口")
 			return
@@ -82,107 +171,207 @@ func (p *pprof) GeneratePprof(reqRunProfile dto.ReqRunProfile) (list []PprofInfo
 		if err != nil {
 			elog.Error("Get clusterManager failed while gen pprof.",
 				zap.String("requestClusterId", reqRunProfile.ClusterName), zap.Error(err))
-			err = fmt.Errorf("target cluster may not exist, please retry")
+			err = fmt.Errorf("target cluster unavailable: %w", err)
 			return
 		}
-
-		eg := errgroup.Group{}
-		for _, _profileType := range profileTypes {
-			profileType := _profileType
-			eg.Go(func() error {
-				params := make(map[string]string)
-				if profileType == "profile" {
-					params["seconds"] = strconv.Itoa(reqRunProfile.Seconds)
-				}
-
-				err = p.generateGraphByK8S(reqRunProfile, targetClusterManager, profileType, params)
-				if err != nil {
-					return err
-				}
-				list = append(list, PprofInfo{
-					Type: profileType,
-					Url:  getPprofUrl(profileType, reqRunProfile.UniqueKey, "flame"),
-				})
-				list = append(list, PprofInfo{
-					Type: profileType,
-					Url:  getPprofUrl(profileType, reqRunProfile.UniqueKey, "profile"),
-				})
-				return nil
-			})
-		}
-		err = eg.Wait()
+		defer targetClusterManager.Release()
+		err = kube.CheckClusterHealthy(reqRunProfile.ClusterName)
 		if err != nil {
 			return
 		}
-		return
+		pod, podErr := targetClusterManager.GetPod(reqRunProfile.Namespace, reqRunProfile.PodName)
+		if podErr != nil {
+			err = fmt.Errorf("get pod failed: %w", podErr)
+			return
+		}
+		if pod.Status.Phase != corev1.PodRunning {
+			err = fmt.Errorf("pod %s is %s, not running", pod.Name, pod.Status.Phase)
+			return
+		}
+		podWorkload := targetClusterManager.GetPodWorkload(pod)
+		workload = &podWorkload
+		fetch = func(profileType string, params map[string]string) ([]byte, error) {
+			return p.fetchByK8S(reqRunProfile, targetClusterManager, profileType, params)
+		}
 	case ProfileRunTypeAddr:
 		if reqRunProfile.Addr == "" {
 			err = errors.New("addr cannot be empty")
 			return
 		}
-		reqRunProfile.UniqueKey = fmt.Sprintf("%s/%s/%s_%d", reqRunProfile.ClusterName, "custom", reqRunProfile.Addr, time.Now().UnixMilli())
-		eg := errgroup.Group{}
-		for _, _profileType := range profileTypes {
-			profileType := _profileType
-			eg.Go(func() error {
-				params := make(map[string]string)
-				if profileType == "profile" {
-					params["seconds"] = strconv.Itoa(reqRunProfile.Seconds)
-				}
-				elog.Info("pprof", elog.String("profileType", profileType), elog.Any("reqRunProfile", reqRunProfile))
-				err = p.generateGraphByAddr(reqRunProfile, profileType, params)
-				if err != nil {
-					return err
-				}
-				list = append(list, PprofInfo{
-					Type: profileType,
-					Url:  getPprofUrl(profileType, reqRunProfile.UniqueKey, "flame"),
-				})
-				list = append(list, PprofInfo{
-					Type: profileType,
-					Url:  getPprofUrl(profileType, reqRunProfile.UniqueKey, "profile"),
-				})
-				return nil
-			})
-		}
-
-		err = eg.Wait()
-		if err != nil {
+		if reqRunProfile.ClusterName != "" && !validClusterName(reqRunProfile.ClusterName) {
+			err = fmt.Errorf("invalid cluster name %s", reqRunProfile.ClusterName)
 			return
 		}
-		return
+		reqRunProfile.UniqueKey = strings.TrimPrefix(fmt.Sprintf("%s/%s/%s_%d", reqRunProfile.ClusterName, customNamespace, reqRunProfile.Addr, now.UnixMilli()), "/")
+		fetch = func(profileType string, params map[string]string) ([]byte, error) {
+			elog.Info("pprof", elog.String("profileType", profileType), elog.Any("reqRunProfile", reqRunProfile))
+			return p.fetchByAddr(reqRunProfile, profileType, params)
+		}
 	default:
 		err = fmt.Errorf("ProfileRunType (%s) isn't supported currently", reqRunProfile.Mode)
 		return
 	}
+	capture := newCapture(reqRunProfile, now)
+	capture.Workload = workload
+	capture.Labels = labels
+	return p.runCapture(capture, profileTypes, fetch)
+}
+
+type fetchFunc func(profileType string, params map[string]string) ([]byte, error)
+
+// runCapture fetches every profile kind concurrently, stores their raw profiles and records the capture metadata.
+// Graphs are rendered on their first request.
+func (p *pprof) runCapture(capture *Capture, kinds []string, fetch fetchFunc) (list []PprofInfo, err error) {
+	list = make([]PprofInfo, 0)
+	ctx := context.TODO()
+	err = p.saveCapture(ctx, capture)
+	if err != nil {
+		return
+	}
 
+	var (
+		mu     sync.Mutex
+		hashes []string
+	)
+	// blobs stay referenced until the final manifest is in the index
+	defer func() {
+		for _, hash := range hashes {
+			p.blobs.release(hash)
+		}
+	}()
+	capture.Profiles = make(map[string]ProfileRef)
+	capture.BuildIDs = make(map[string]string)
+	eg := errgroup.Group{}
+	for _, _profileType := range kinds {
+		profileType := _profileType
+		eg.Go(func() error {
+			params := make(map[string]string)
+			if profileType == "profile" {
+				params["seconds"] = strconv.Itoa(capture.Seconds)
+			}
+			rawProfileData, err := fetch(profileType, params)
+			if err != nil {
+				return err
+			}
+			hash := hashProfile(rawProfileData)
+			p.blobs.acquire(hash)
+			mu.Lock()
+			hashes = append(hashes, hash)
+			mu.Unlock()
+			size, err := p.saveRaw(rawProfileData, hash, profileType)
+			if err != nil {
+				return err
+			}
+			// parsed once for the sample type check and the build ID, unparsable profiles get neither
+			sampleIndex, buildID := "", ""
+			if prof, err := profile.ParseData(rawProfileData); err == nil {
+				// the requested sample type only applies to the kinds having it, e.g. alloc_space of heap
+				if _, err = findSampleType(prof, capture.SampleIndex); err == nil {
+					sampleIndex = capture.SampleIndex
+				}
+				buildID = profileBuildID(prof)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			capture.Size += size
+			capture.Kinds = append(capture.Kinds, profileType)
+			capture.Profiles[profileType] = ProfileRef{Hash: hash, Size: size}
+			if buildID != "" {
+				capture.BuildIDs[profileType] = buildID
+			}
+			list = append(list, PprofInfo{
+				Type: profileType,
+				Url:  getPprofUrl(profileType, capture.Key, "flame", sampleIndex),
+			})
+			list = append(list, PprofInfo{
+				Type: profileType,
+				Url:  getPprofUrl(profileType, capture.Key, "profile", sampleIndex),
+			})
+			return nil
+		})
+	}
+	err = eg.Wait()
+	capture.finish(err)
+	if saveErr := p.saveCapture(ctx, capture); saveErr != nil {
+		elog.Error("save capture error", zap.String("key", capture.Key), zap.Error(saveErr))
+		if err == nil {
+			err = saveErr
+		}
+	}
+	return
 }
+
+// FindGraphData 返回图, 指定 sampleIndex 的图以及 png/pdf 格式在首次请求时渲染并缓存到存储, 指定过滤条件的图只缓存在内存
 func (p *pprof) FindGraphData(req dto.ReqPprofGraph) (data []byte, err error) {
-	svgPath := filepath.Join(req.Url, req.GoType+"_"+req.SvgType+".svg")
-	// SVG
 	switch req.SvgType {
-	case "profile":
-		fallthrough
-	case "flame":
-		data, err = p.storage.GetBytes(context.TODO(), svgPath)
+	case "profile", "flame", "html":
 	default:
 		return nil, fmt.Errorf("no exist svg type: " + req.SvgType)
 	}
-	return
+	variant := graphVariant{
+		sampleIndex: req.SampleIndex,
+		filters: graphFilters{
+			Focus:    req.Focus,
+			Ignore:   req.Ignore,
+			Hide:     req.Hide,
+			Show:     req.Show,
+			TagFocus: req.TagFocus,
+			Prune:    req.Prune,
+		},
+	}
+	image := imageOptions{format: req.Format, width: req.Width, dpi: req.Dpi}
+	if err = image.validate(); err != nil {
+		return nil, err
+	}
+	if req.SvgType == "html" && !image.svg() {
+		return nil, fmt.Errorf("html graph can't be converted to %s", image.format)
+	}
+	ctx := context.TODO()
+	c, err := p.GetCapture(req.Url)
+	if err != nil {
+		// captures written by another replica may not be indexed yet
+		if variant != (graphVariant{}) || !image.svg() {
+			return nil, err
+		}
+		return p.storage.GetBytes(ctx, path.Join(strings.Trim(req.Url, "/"), legacyArtifactName(req.GoType, req.SvgType+".svg")))
+	}
+	if !image.svg() {
+		return p.image(ctx, c, req.GoType, req.SvgType, variant, image)
+	}
+	return p.graph(ctx, c, req.GoType, req.SvgType, variant)
 }
 
 func (p *pprof) GetPprofList(req dto.ReqGetPprofList) (list []dto.RespGetPprofListItem, err error) {
-	key := fmt.Sprintf("%s/%s", req.ClusterName, req.Namespace)
-	nameList, err := p.storage.List(context.TODO(), key)
+	selector, err := ParseLabels(req.Labels)
 	if err != nil {
-		return nil, err
+		return
 	}
-	for _, item := range nameList {
-		n := strings.LastIndex(item, "_")
+	captures := p.index.list(func(c *Capture) bool {
+		if c.ClusterName != req.ClusterName || c.Namespace != req.Namespace {
+			return false
+		}
+		if req.PodName != "" && !strings.HasPrefix(c.PodName, req.PodName) && !strings.HasPrefix(c.Addr, req.PodName) {
+			return false
+		}
+		if !matchLabels(c.Labels, selector) {
+			return false
+		}
+		return req.Status == "" || c.Status == req.Status
+	})
+	list = make([]dto.RespGetPprofListItem, 0, len(captures))
+	for _, c := range captures {
 		list = append(list, dto.RespGetPprofListItem{
-			Url:     fmt.Sprintf("%s/%s", key, item),
-			PodName: item[:n],
-			Ctime:   cast.ToInt64(item[n+1:]) / 1e3,
+			Url:      c.Key,
+			PodName:  c.PodName,
+			Addr:     c.Addr,
+			Ctime:    c.Ctime,
+			Seconds:  c.Seconds,
+			Operator: c.Operator,
+			Kinds:    c.Kinds,
+			Status:   c.Status,
+			Error:    c.Error,
+			Labels:   c.Labels,
+			Note:     c.Note,
 		})
 	}
 	return
@@ -214,7 +403,7 @@ func (p *pprof) checkEnv() (err error) {
 	return
 }
 
-func (p *pprof) generateGraphByAddr(reqRunProfile dto.ReqRunProfile, pprofResName string, params map[string]string) (err error) {
+func (p *pprof) fetchByAddr(reqRunProfile dto.ReqRunProfile, pprofResName string, params map[string]string) (rawProfileData []byte, err error) {
 	targetUrl := fmt.Sprintf("%s/debug/pprof/%s", reqRunProfile.Addr, pprofResName)
 	if pprofResName == "fgprof" {
 		targetUrl = fmt.Sprintf("%s/debug/%s", reqRunProfile.Addr, pprofResName)
@@ -234,7 +423,7 @@ func (p *pprof) generateGraphByAddr(reqRunProfile dto.ReqRunProfile, pprofResNam
 
 	req, err := http.NewRequest("GET", targetUrl, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	q := req.URL.Query()
 	for key, val := range params {
@@ -252,22 +441,16 @@ func (p *pprof) generateGraphByAddr(reqRunProfile dto.ReqRunProfile, pprofResNam
 		return
 	}
 
-	rawProfileData, err := ioutil.ReadAll(res.Body)
+	rawProfileData, err = ioutil.ReadAll(res.Body)
 	if err != nil {
 		err = errors.Wrapf(err, "请求地址(%s)获取 %s profile response数据失败. err=%s", targetUrl, pprofResName, err.Error())
 		return
 	}
-
-	err = p.genSvg(rawProfileData, reqRunProfile.UniqueKey, pprofResName)
-	if err != nil {
-		err = fmt.Errorf("generateGraphByAddr err: %w", err)
-		return
-	}
 	return
 }
 
-func (p *pprof) generateGraphByK8S(reqRunProfile dto.ReqRunProfile, clusterManager *kube.ClusterManager,
-	pprofResName string, params map[string]string) (err error) {
+func (p *pprof) fetchByK8S(reqRunProfile dto.ReqRunProfile, clusterManager *kube.ClusterManager,
+	pprofResName string, params map[string]string) (rawProfileData []byte, err error) {
 
 	resourceName := fmt.Sprintf("%s:%d", reqRunProfile.PodName, reqRunProfile.Port)
 	suffix := "debug/pprof/" + pprofResName
@@ -293,71 +476,35 @@ func (p *pprof) generateGraphByK8S(reqRunProfile dto.ReqRunProfile, clusterManag
 		err = errors.Wrapf(err, "请求治理端口获取 %s profile 数据失败. err=%s", pprofResName, err.Error())
 		return
 	}
-	rawProfileData, _ := res.Raw()
-	err = p.genSvg(rawProfileData, reqRunProfile.UniqueKey, pprofResName)
-	if err != nil {
-		err = fmt.Errorf("generateGraphByK8S err: %w", err)
-		return
-	}
+	rawProfileData, _ = res.Raw()
 	return
 }
 
-func (p *pprof) genSvg(rawProfileData []byte, uniqueKey string, pprofType string) (err error) {
-	tmpFileDir := filepath.Join(os.TempDir(), uniqueKey)
-	err = os.MkdirAll(tmpFileDir, os.ModePerm)
-	if err != nil {
-		err = errors.Wrap(err, "创建临时目录失败")
-		return
-	}
-
-	rawStorePath := path.Join(tmpFileDir, pprofType+".bin")
-	err = ioutil.WriteFile(rawStorePath, rawProfileData, os.ModePerm)
-	if err != nil {
-		err = errors.Wrap(err, "临时文件写入失败")
-		return
-	}
-	// 保存 bin 文件
-	err = p.storage.PutBytes(context.TODO(), filepath.Join(uniqueKey, pprofType+".bin"), rawProfileData)
-	if err != nil {
-		err = errors.Wrap(err, "临时文件保存失败")
-		return
-	}
-
-	var (
-		flameSvgByte   []byte
-		profileSvgByte []byte
-	)
-
-	// 生成火焰图 SVG
-	flameSvgByte, err = p.generateFlameSvg(rawStorePath)
+// saveRaw 按内容哈希保存原始数据, 内容相同的 profile 直接复用已有的 blob, 图在首次请求时渲染.
+// 返回该 blob 在存储中占用的字节数
+func (p *pprof) saveRaw(rawProfileData []byte, hash string, pprofType string) (size int64, err error) {
+	ctx := context.TODO()
+	key := path.Join(blobDir(hash), blobRawName)
+	stored, err := storage.Exists(ctx, p.storage, key)
 	if err != nil {
-		err = fmt.Errorf("生成火焰图失败, %w", err)
+		err = fmt.Errorf("检查 profile blob 失败: %w", err)
 		return
 	}
-
-	err = p.storage.PutBytes(context.TODO(), filepath.Join(uniqueKey, pprofType+"_flame.svg"), flameSvgByte)
-	if err != nil {
-		err = fmt.Errorf("保存火焰图失败: %w", err)
-		return
+	if stored {
+		elog.Info("profile blob reused", zap.String("hash", hash), zap.String("pprofType", pprofType))
 	}
-
-	// 生成Profile SVG
-	profileSvgPath := path.Join(tmpFileDir, pprofType+"_profile.svg")
-	profileSvgByte, err = p.generateProfileSvg(rawStorePath, profileSvgPath)
+	// a reused blob is written again, so the orphan sweep of a replica that can't see this capture yet
+	// finds it within the grace period
+	err = p.storage.PutBytes(ctx, key, rawProfileData)
 	if err != nil {
-		err = fmt.Errorf("生成Profile图失败, %w", err)
+		err = errors.Wrap(err, "保存 profile 原始数据失败")
 		return
 	}
-
-	err = p.storage.PutBytes(context.TODO(), filepath.Join(uniqueKey, pprofType+"_profile.svg"), profileSvgByte)
-	if err != nil {
-		err = fmt.Errorf("保存 Profile 图失败: %w", err)
-	}
-	return nil
+	return p.blobSize(ctx, hash)
 }
 
-// 生成火焰图SVG
-func (p *pprof) generateFlameSvg(rawFilePath string) (data []byte, err error) {
+// 生成火焰图SVG, sampleIndex 为 -1 时使用默认的 sample 类型
+func (p *pprof) generateFlameSvg(rawFilePath string, sampleIndex int) (data []byte, err error) {
 	out, err := exec.Command("bash", "-c", "go tool pprof -raw "+rawFilePath).Output()
 	if err != nil {
 		return nil, fmt.Errorf("go tool pprof -raw err: %v", err)
@@ -368,8 +515,11 @@ func (p *pprof) generateFlameSvg(rawFilePath string) (data []byte, err error) {
 		return nil, fmt.Errorf("could not parse raw pprof output: %v", err)
 	}
 
-	sampleIndex := torchPprof.SelectSample([]string{}, profile.SampleNames)
-	flameInput, err := renderer.ToFlameInput(profile, sampleIndex)
+	var args []string
+	if sampleIndex >= 0 {
+		args = []string{"-sample_index", strconv.Itoa(sampleIndex)}
+	}
+	flameInput, err := renderer.ToFlameInput(profile, torchPprof.SelectSample(args, profile.SampleNames))
 	if err != nil {
 		return nil, fmt.Errorf("could not convert stacks to flamegraph input: %v", err)
 	}
@@ -386,20 +536,23 @@ func (p *pprof) generateFlameSvg(rawFilePath string) (data []byte, err error) {
 	return
 }
 
-func (p *pprof) generateProfileSvg(rawFilePath, svgFilePath string) (data []byte, err error) {
-	_, err = exec.Command("bash", "-c", fmt.Sprintf("go tool pprof -svg %s > %s", rawFilePath, svgFilePath)).Output()
-	if err != nil {
-		return nil, fmt.Errorf("profile svg 生成失败: %v", err)
+// generateProfileSvg 生成调用图 SVG, sampleIndex 为空时使用默认的 sample 类型
+func (p *pprof) generateProfileSvg(rawFilePath, svgFilePath, sampleIndex string) (err error) {
+	flags := ""
+	if sampleIndex != "" {
+		flags = "-sample_index=" + sampleIndex + " "
 	}
-
-	data, err = ioutil.ReadFile(svgFilePath)
+	_, err = exec.Command("bash", "-c", fmt.Sprintf("go tool pprof -svg %s%s > %s", flags, rawFilePath, svgFilePath)).Output()
 	if err != nil {
-		return nil, errors.Wrap(err, "读取Profile SVG文件失败")
+		return fmt.Errorf("profile svg 生成失败: %v", err)
 	}
-
 	return
 }
 
-func getPprofUrl(profileType, UniqueKey, svgType string) string {
-	return fmt.Sprintf(econf.GetString("app.rootURL")+"/graph?goType=%s&url=%s&svgType=%s", profileType, UniqueKey, svgType)
+func getPprofUrl(profileType, UniqueKey, svgType, sampleIndex string) string {
+	url := fmt.Sprintf(econf.GetString("app.rootURL")+"/graph?goType=%s&url=%s&svgType=%s", profileType, UniqueKey, svgType)
+	if sampleIndex != "" {
+		url += "&sampleIndex=" + sampleIndex
+	}
+	return url
 }
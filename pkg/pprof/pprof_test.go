@@ -0,0 +1,48 @@
+package pprof
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestRunCapture(t *testing.T) {
+	p := newTestPprof(t)
+	heap, err := profile.ParseData(testProfile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	heap.SampleType = []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}, {Type: "inuse_space", Unit: "bytes"}}
+	heap.Mapping = []*profile.Mapping{{ID: 1, Limit: 0x10000, File: "/app", BuildID: "7d9f0a"}}
+	for _, loc := range heap.Location {
+		loc.Mapping = heap.Mapping[0]
+	}
+	var raw bytes.Buffer
+	if err = heap.Write(&raw); err != nil {
+		t.Fatal(err)
+	}
+	raws := map[string][]byte{"heap": raw.Bytes(), "profile": testProfile(t)}
+	fetch := func(profileType string, _ map[string]string) ([]byte, error) {
+		return raws[profileType], nil
+	}
+
+	c := &Capture{Key: "c/ns/pod_1", Status: CaptureStatusRunning, SampleIndex: "alloc_space"}
+	list, err := p.runCapture(c, []string{"heap", "profile"}, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BuildIDs["heap"] != "7d9f0a" || len(c.BuildIDs) != 1 {
+		t.Errorf("BuildIDs = %v, want only the heap build ID", c.BuildIDs)
+	}
+	// the sample index only applies to the kind having it
+	if len(list) != 4 {
+		t.Fatalf("runCapture() listed %d graphs, want 4", len(list))
+	}
+	for _, info := range list {
+		if withIndex := strings.Contains(info.Url, "alloc_space"); withIndex != (info.Type == "heap") {
+			t.Errorf("%s graph url %s, sample index expected only for heap", info.Type, info.Url)
+		}
+	}
+}
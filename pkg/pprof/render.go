@@ -0,0 +1,370 @@
+package pprof
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/pprof/profile"
+
+	"goprobe/pkg/dto"
+	"goprobe/pkg/storage"
+)
+
+// sampleIndexRegexp guards sample type names, they end up in file names and go tool pprof command lines.
+var sampleIndexRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// sampleTypeIndex returns the position of sample type sampleIndex in raw, -1 for the default.
+func sampleTypeIndex(raw []byte, sampleIndex string) (int, error) {
+	if sampleIndex == "" {
+		return -1, nil
+	}
+	if !sampleIndexRegexp.MatchString(sampleIndex) {
+		return 0, fmt.Errorf("invalid sample index %q", sampleIndex)
+	}
+	prof, err := profile.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return 0, fmt.Errorf("parse profile error: %w", err)
+	}
+	return findSampleType(prof, sampleIndex)
+}
+
+// findSampleType returns the position of sample type name in prof.
+func findSampleType(prof *profile.Profile, name string) (int, error) {
+	names := make([]string, 0, len(prof.SampleType))
+	for i, st := range prof.SampleType {
+		if st.Type == name {
+			return i, nil
+		}
+		names = append(names, st.Type)
+	}
+	return 0, fmt.Errorf("sample index %s not found, the profile has %s", name, strings.Join(names, ", "))
+}
+
+// graphVariant selects what a graph shows, the zero value is the default graph.
+type graphVariant struct {
+	sampleIndex string
+	filters     graphFilters
+}
+
+func (v graphVariant) validate() error {
+	if v.sampleIndex != "" && !sampleIndexRegexp.MatchString(v.sampleIndex) {
+		return fmt.Errorf("invalid sample index %q", v.sampleIndex)
+	}
+	return v.filters.validate()
+}
+
+// name is the artifact name of a graph variant, e.g. flame.svg, flame_alloc_space.svg, flame_f<filters hash>.svg or html.html.
+// Filtered variants only use it as their key in the graph cache.
+func (v graphVariant) name(svgType string) string {
+	name := svgType
+	if v.sampleIndex != "" {
+		name += "_" + v.sampleIndex
+	}
+	if key := v.filters.key(); key != "" {
+		name += "_f" + key
+	}
+	if svgType == "html" {
+		return name + ".html"
+	}
+	return name + ".svg"
+}
+
+// stored reports whether the graphs of a variant are stored with the capture. Filters take arbitrary
+// values, their graphs are only kept in the graph cache so unauthenticated requests can't grow the storage.
+func (v graphVariant) stored() bool {
+	return v.filters.key() == ""
+}
+
+// graph returns a graph of a profile kind, rendering and storing the variant on first request.
+// Captures only store raw profiles, the default graphs are rendered the same way.
+func (p *pprof) graph(ctx context.Context, c *Capture, kind, svgType string, v graphVariant) ([]byte, error) {
+	if err := v.validate(); err != nil {
+		return nil, err
+	}
+	if err := checkKind(c, kind); err != nil {
+		return nil, err
+	}
+	key := artifactKey(c, kind, v.name(svgType))
+	if !v.stored() {
+		return p.renderCached(key, func() ([]byte, error) {
+			return p.renderGraph(ctx, c, kind, svgType, v)
+		})
+	}
+	data, err := p.storage.GetBytes(ctx, key)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return data, err
+	}
+	return p.render(key, func() ([]byte, error) {
+		data, err := p.renderGraph(ctx, c, kind, svgType, v)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.storeRender(ctx, c, kind, key, data); err != nil {
+			return nil, fmt.Errorf("保存 %s 图失败: %w", svgType, err)
+		}
+		return data, nil
+	})
+}
+
+// storeRender stores an artifact rendered on request under key and records its bytes in c.
+func (p *pprof) storeRender(ctx context.Context, c *Capture, kind, key string, data []byte) error {
+	if err := p.storage.PutBytes(ctx, key, data); err != nil {
+		return err
+	}
+	// compressed artifacts take less than data
+	size := int64(len(data))
+	if info, err := p.storage.Stat(ctx, key); err == nil {
+		size = info.Size
+	}
+	p.recordArtifactBytes(ctx, c, kind, size)
+	return nil
+}
+
+// render runs fn once for the concurrent requests of the artifact key and shares its result.
+func (p *pprof) render(key string, fn func() ([]byte, error)) ([]byte, error) {
+	data, err, _ := p.renders.Do(key, func() (interface{}, error) {
+		return fn()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// renderCached renders an artifact that isn't stored once for the concurrent requests of key,
+// keeping it in the graph cache.
+func (p *pprof) renderCached(key string, fn func() ([]byte, error)) ([]byte, error) {
+	if data, ok := p.graphs.get(key); ok {
+		return data, nil
+	}
+	return p.render(key, func() ([]byte, error) {
+		data, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		p.graphs.put(key, data)
+		return data, nil
+	})
+}
+
+func (p *pprof) renderGraph(ctx context.Context, c *Capture, kind, svgType string, v graphVariant) ([]byte, error) {
+	r, err := p.openRaw(ctx, c, kind)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	index, err := sampleTypeIndex(raw, v.sampleIndex)
+	if err != nil {
+		return nil, err
+	}
+	raw, err = v.filters.apply(raw)
+	if err != nil {
+		return nil, err
+	}
+	if svgType == "html" {
+		return renderFlameGraphHTML(path.Join(c.Key, kind), raw, v.sampleIndex)
+	}
+
+	tmpFileDir, err := ioutil.TempDir("", "goprobe-")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(tmpFileDir)
+	rawPath := path.Join(tmpFileDir, kind+".bin")
+	if err = ioutil.WriteFile(rawPath, raw, 0644); err != nil {
+		return nil, fmt.Errorf("临时文件写入失败: %w", err)
+	}
+
+	switch svgType {
+	case "flame":
+		return p.generateFlameSvg(rawPath, index)
+	case "profile":
+		svgPath := path.Join(tmpFileDir, kind+"_profile.svg")
+		if err = p.generateProfileSvg(rawPath, svgPath, v.sampleIndex); err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(svgPath)
+	default:
+		return nil, fmt.Errorf("no exist svg type: " + svgType)
+	}
+}
+
+// Rerender drops the cached graphs of capture req.Url, or of every capture with req.All, so they are
+// rendered again on their next request, e.g. after a renderer upgrade. With req.Eager the default
+// graphs are rendered right away. It returns the keys of the captures handled.
+func (p *pprof) Rerender(req dto.ReqRerender, operator string) (rerendered []string, err error) {
+	var captures []*Capture
+	switch {
+	case req.Url != "":
+		c, err := p.GetCapture(req.Url)
+		if err != nil {
+			return nil, err
+		}
+		captures = []*Capture{c}
+	case req.All:
+		captures = p.index.list(func(c *Capture) bool { return c.Status != CaptureStatusRunning })
+	default:
+		return nil, fmt.Errorf("url or all is required")
+	}
+	ctx := context.TODO()
+	// blobs shared by several captures are dropped once
+	dropped := make(map[string]bool)
+	defer func() {
+		if len(rerendered) == 0 {
+			return
+		}
+		detail := "rerender"
+		if req.All {
+			detail = "rerender all"
+		}
+		p.audit(ctx, AuditRecord{Operator: operator, Action: AuditActionUpdate, Keys: rerendered, Detail: detail})
+	}()
+	for _, c := range captures {
+		if c.Status == CaptureStatusRunning {
+			return rerendered, fmt.Errorf("capture %s is still running", c.Key)
+		}
+		for _, kind := range c.Kinds {
+			if err = p.dropRenders(ctx, c, kind, dropped); err != nil {
+				return rerendered, err
+			}
+			if !req.Eager {
+				continue
+			}
+			for _, svgType := range []string{"flame", "profile"} {
+				if _, err = p.graph(ctx, c, kind, svgType, graphVariant{}); err != nil {
+					return rerendered, fmt.Errorf("render %s %s graph of %s error: %w", kind, svgType, c.Key, err)
+				}
+			}
+		}
+		rerendered = append(rerendered, c.Key)
+	}
+	return rerendered, nil
+}
+
+// dropRenders deletes the cached graphs of a profile kind, keeping its raw profile.
+func (p *pprof) dropRenders(ctx context.Context, c *Capture, kind string, dropped map[string]bool) error {
+	dir, keep, prefix := c.Key, legacyArtifactName(kind, blobRawName), kind+"_"
+	if ref, ok := c.Profiles[kind]; ok {
+		if dropped[ref.Hash] {
+			return nil
+		}
+		dropped[ref.Hash] = true
+		dir, keep, prefix = blobDir(ref.Hash), blobRawName, ""
+	}
+	p.graphs.dropPrefix(dir + "/" + prefix)
+	names, err := p.storage.List(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("list %s graphs of %s error: %w", kind, c.Key, err)
+	}
+	var freed int64
+	defer func() {
+		if freed > 0 {
+			p.recordArtifactBytes(ctx, c, kind, -freed)
+		}
+	}()
+	for _, name := range names {
+		if name == keep || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		info, err := p.storage.Stat(ctx, path.Join(dir, name))
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("stat %s error: %w", name, err)
+		}
+		if err = p.storage.Delete(ctx, path.Join(dir, name)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("delete %s error: %w", name, err)
+		}
+		freed += info.Size
+	}
+	return nil
+}
+
+// defaultGraphCacheBytes bounds the graph cache when render.cacheBytes isn't set.
+const defaultGraphCacheBytes = 64 << 20
+
+// graphCache keeps the graphs of filtered variants in memory, they aren't stored. The least recently
+// used are evicted once the total size exceeds maxBytes. A nil cache keeps nothing.
+type graphCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	entries  map[string]*list.Element
+	lru      *list.List // of *cachedGraph, most recently used first
+}
+
+type cachedGraph struct {
+	key  string
+	data []byte
+}
+
+func newGraphCache(maxBytes int) *graphCache {
+	if maxBytes <= 0 {
+		maxBytes = defaultGraphCacheBytes
+	}
+	return &graphCache{maxBytes: maxBytes, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (g *graphCache) get(key string) ([]byte, bool) {
+	if g == nil {
+		return nil, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e, ok := g.entries[key]
+	if !ok {
+		return nil, false
+	}
+	g.lru.MoveToFront(e)
+	return e.Value.(*cachedGraph).data, true
+}
+
+func (g *graphCache) put(key string, data []byte) {
+	if g == nil || len(data) > g.maxBytes {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if e, ok := g.entries[key]; ok {
+		g.remove(e)
+	}
+	g.entries[key] = g.lru.PushFront(&cachedGraph{key: key, data: data})
+	g.bytes += len(data)
+	for g.bytes > g.maxBytes {
+		g.remove(g.lru.Back())
+	}
+}
+
+// dropPrefix evicts the graphs whose key starts with prefix.
+func (g *graphCache) dropPrefix(prefix string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, e := range g.entries {
+		if strings.HasPrefix(key, prefix) {
+			g.remove(e)
+		}
+	}
+}
+
+func (g *graphCache) remove(e *list.Element) {
+	cached := g.lru.Remove(e).(*cachedGraph)
+	delete(g.entries, cached.key)
+	g.bytes -= len(cached.data)
+}
@@ -0,0 +1,120 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"testing"
+
+	"goprobe/pkg/dto"
+)
+
+func TestSampleTypeIndex(t *testing.T) {
+	raw := testProfile(t)
+	tests := []struct {
+		sampleIndex string
+		want        int
+		wantErr     bool
+	}{
+		{"", -1, false},
+		{"samples", 0, false},
+		{"cpu", 1, false},
+		{"alloc_space", 0, true},
+		{"../cpu", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := sampleTypeIndex(raw, tt.sampleIndex)
+		if (err != nil) != tt.wantErr || (err == nil && got != tt.want) {
+			t.Errorf("sampleTypeIndex(%q) = %d, %v, want %d, error %v", tt.sampleIndex, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFindGraphDataVariant(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+	// variants rendered before are served from storage
+	variant := path.Join(blobDir(c.Profiles["profile"].Hash), graphVariant{sampleIndex: "cpu"}.name("flame"))
+	if err := p.storage.PutBytes(ctx, variant, []byte("<svg>cpu</svg>")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := p.FindGraphData(dto.ReqPprofGraph{Url: c.Key, GoType: "profile", SvgType: "flame", SampleIndex: "cpu"})
+	if err != nil || string(data) != "<svg>cpu</svg>" {
+		t.Errorf("FindGraphData() = %q, %v, want the stored variant", data, err)
+	}
+	if _, err = p.FindGraphData(dto.ReqPprofGraph{Url: c.Key, GoType: "profile", SvgType: "flame", SampleIndex: "alloc_space"}); err == nil {
+		t.Error("FindGraphData() with a sample type missing from the profile succeeded")
+	}
+	if _, err = p.FindGraphData(dto.ReqPprofGraph{Url: c.Key, GoType: "profile", SvgType: "flame", SampleIndex: "../../x"}); err == nil {
+		t.Error("FindGraphData() with an invalid sample index succeeded")
+	}
+}
+
+func TestFindGraphDataFiltered(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	p.graphs = newGraphCache(0)
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+	req := dto.ReqPprofGraph{Url: c.Key, GoType: "profile", SvgType: "html", Focus: "main.work"}
+	data, err := p.FindGraphData(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := blobDir(c.Profiles["profile"].Hash)
+	names, err := p.storage.List(ctx, dir)
+	if err != nil || len(names) != 1 || names[0] != blobRawName {
+		t.Errorf("blob files = %v, %v, want the filtered graph kept out of storage", names, err)
+	}
+	key := path.Join(dir, graphVariant{filters: graphFilters{Focus: "main.work"}}.name("html"))
+	if cached, ok := p.graphs.get(key); !ok || !bytes.Equal(cached, data) {
+		t.Error("filtered graph isn't in the graph cache")
+	}
+	if _, err = p.Rerender(dto.ReqRerender{Url: c.Key}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.graphs.get(key); ok {
+		t.Error("rerender kept the cached filtered graph")
+	}
+}
+
+func TestRenderCached(t *testing.T) {
+	p := newTestPprof(t)
+	p.graphs = newGraphCache(0)
+	renders := 0
+	render := func() ([]byte, error) {
+		renders++
+		return []byte("<svg>focus</svg>"), nil
+	}
+	for i := 0; i < 2; i++ {
+		if data, err := p.renderCached("key", render); err != nil || string(data) != "<svg>focus</svg>" {
+			t.Fatalf("renderCached() = %q, %v", data, err)
+		}
+	}
+	if renders != 1 {
+		t.Errorf("rendered %d times, want the cached graph served", renders)
+	}
+	if !(graphVariant{sampleIndex: "cpu"}).stored() || (graphVariant{filters: graphFilters{Focus: "main.work"}}).stored() {
+		t.Error("only unfiltered variants are stored")
+	}
+}
+
+func TestGraphCacheEvict(t *testing.T) {
+	g := newGraphCache(10)
+	g.put("a", []byte("1234"))
+	g.put("b", []byte("1234"))
+	g.get("a")
+	g.put("c", []byte("1234"))
+	if _, ok := g.get("b"); ok {
+		t.Error("least recently used graph kept over the limit")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := g.get(key); !ok {
+			t.Errorf("graph %s evicted", key)
+		}
+	}
+	g.put("d", make([]byte, 11))
+	if _, ok := g.get("d"); ok || g.bytes != 8 {
+		t.Errorf("graph larger than the cache kept, %d bytes cached", g.bytes)
+	}
+}
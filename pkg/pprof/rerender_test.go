@@ -0,0 +1,108 @@
+package pprof
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goprobe/pkg/dto"
+)
+
+func TestRerender(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+	dir := blobDir(c.Profiles["profile"].Hash)
+	legacy := &Capture{Key: "c/ns/legacy_1", Status: CaptureStatusSuccess, Kinds: []string{"heap"}}
+	if err := p.saveCapture(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.storage.PutBytes(ctx, path.Join(legacy.Key, "heap.bin"), []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	for _, render := range []struct {
+		c         *Capture
+		kind, key string
+	}{
+		{c, "profile", path.Join(dir, "flame.svg")},
+		{c, "profile", path.Join(dir, "profile_samples.svg")},
+		{legacy, "heap", path.Join(legacy.Key, "heap_flame.svg")},
+	} {
+		if err := p.storeRender(ctx, render.c, render.kind, render.key, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sizes := func() (int64, int64) {
+		blob, _ := p.GetCapture(c.Key)
+		legacy, _ := p.GetCapture(legacy.Key)
+		return blob.Profiles["profile"].Size, legacy.Size
+	}
+	if blob, legacy := sizes(); blob != 8 || legacy != 4 {
+		t.Errorf("rendered bytes recorded %d in the blob, %d in the legacy capture, want 8 and 4", blob, legacy)
+	}
+
+	if _, err := p.Rerender(dto.ReqRerender{}, "tester"); err == nil {
+		t.Error("rerender without url or all should fail")
+	}
+	got, err := p.Rerender(dto.ReqRerender{All: true}, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c/ns/legacy_1", "c/ns/pod_1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rerendered %v, want %v", got, want)
+	}
+	for d, want := range map[string][]string{
+		dir:        {blobRawName},
+		legacy.Key: {"heap.bin", manifestName},
+	} {
+		names, err := p.storage.List(ctx, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s has %v after rerender, want %v", d, names, want)
+		}
+	}
+	if blob, legacy := sizes(); blob != 0 || legacy != 0 {
+		t.Errorf("dropped graphs still recorded: %d in the blob, %d in the legacy capture", blob, legacy)
+	}
+	// graphs are rendered again on request
+	if _, err = p.FindGraphData(dto.ReqPprofGraph{Url: c.Key, GoType: "profile", SvgType: "html"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderSingleflight(t *testing.T) {
+	p := &pprof{}
+	var calls int32
+	release := make(chan struct{})
+	var started, wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			data, err := p.render("key", func() ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return []byte("graph"), nil
+			})
+			if err != nil || string(data) != "graph" {
+				t.Errorf("render() = %s, %v", data, err)
+			}
+		}()
+	}
+	// let every caller join the in-flight render
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("rendered %d times, want once", calls)
+	}
+}
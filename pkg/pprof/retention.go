@@ -0,0 +1,162 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gotomicro/ego/core/econf"
+	"github.com/gotomicro/ego/core/elog"
+	"go.uber.org/zap"
+)
+
+// RetentionPolicy decides which captures the GC removes, zero values disable a rule.
+type RetentionPolicy struct {
+	MaxAge        time.Duration
+	MaxTotalBytes int64
+	MaxPerPod     int
+	KeepPinned    bool
+}
+
+func retentionPolicyFromConfig() RetentionPolicy {
+	policy := RetentionPolicy{
+		MaxAge:        econf.GetDuration("retention.maxAge"),
+		MaxTotalBytes: econf.GetInt64("retention.maxTotalBytes"),
+		MaxPerPod:     econf.GetInt("retention.maxPerPod"),
+		KeepPinned:    true,
+	}
+	if econf.Get("retention.keepPinned") != nil {
+		policy.KeepPinned = econf.GetBool("retention.keepPinned")
+	}
+	return policy
+}
+
+// GCJob is the cron job removing captures that fall out of the retention policy.
+func GCJob(ctx context.Context) error {
+	deleted, err := Pprof.GC(ctx, retentionPolicyFromConfig(), time.Now())
+	elog.Info("capture gc finished", zap.Int("deleted", len(deleted)), zap.Error(err))
+	return err
+}
+
+// GC deletes the captures violating policy and returns their keys. Running captures are never removed.
+func (p *pprof) GC(ctx context.Context, policy RetentionPolicy, now time.Time) (deleted []string, err error) {
+	captures := p.index.list(func(c *Capture) bool {
+		return c.Status != CaptureStatusRunning
+	})
+	protected := func(c *Capture) bool {
+		return policy.KeepPinned && c.Pinned
+	}
+	expired := make(map[string]bool)
+
+	if policy.MaxAge > 0 {
+		deadline := now.Add(-policy.MaxAge).Unix()
+		for _, c := range captures {
+			if c.Ctime < deadline && !protected(c) {
+				expired[c.Key] = true
+			}
+		}
+	}
+
+	// captures are sorted newest first
+	if policy.MaxPerPod > 0 {
+		perPod := make(map[string]int)
+		for _, c := range captures {
+			if expired[c.Key] {
+				continue
+			}
+			target := path.Join(c.ClusterName, c.Namespace, c.PodName, c.Addr)
+			perPod[target]++
+			if perPod[target] > policy.MaxPerPod && !protected(c) {
+				expired[c.Key] = true
+			}
+		}
+	}
+
+	if policy.MaxTotalBytes > 0 {
+		// blobs shared by several captures are stored once, they only free space with their last reference.
+		// Captures saved before graphs were rendered into a blob recorded less, the largest size is the latest
+		blobSizes := make(map[string]int64)
+		for _, c := range captures {
+			for _, ref := range c.Profiles {
+				if ref.Size > blobSizes[ref.Hash] {
+					blobSizes[ref.Hash] = ref.Size
+				}
+			}
+		}
+		var total int64
+		refs := make(map[string]int)
+		for _, c := range captures {
+			if expired[c.Key] {
+				continue
+			}
+			total += c.ownSize()
+			for _, ref := range c.Profiles {
+				if refs[ref.Hash]++; refs[ref.Hash] == 1 {
+					total += blobSizes[ref.Hash]
+				}
+			}
+		}
+		oldestFirst := append([]*Capture(nil), captures...)
+		sort.SliceStable(oldestFirst, func(i, j int) bool { return oldestFirst[i].Ctime < oldestFirst[j].Ctime })
+		for _, c := range oldestFirst {
+			if total <= policy.MaxTotalBytes {
+				break
+			}
+			if expired[c.Key] || protected(c) {
+				continue
+			}
+			expired[c.Key] = true
+			total -= c.ownSize()
+			for _, ref := range c.Profiles {
+				if refs[ref.Hash]--; refs[ref.Hash] == 0 {
+					total -= blobSizes[ref.Hash]
+				}
+			}
+		}
+	}
+
+	for _, c := range captures {
+		if !expired[c.Key] {
+			continue
+		}
+		if delErr := p.deleteCapture(ctx, c.Key); delErr != nil {
+			elog.Warn("gc delete capture error", zap.String("key", c.Key), zap.Error(delErr))
+			if err == nil {
+				err = delErr
+			}
+			continue
+		}
+		deleted = append(deleted, c.Key)
+	}
+	if len(deleted) > 0 {
+		p.audit(ctx, AuditRecord{Operator: "gc", Action: AuditActionDelete, Keys: deleted, Detail: "retention"})
+	}
+	if swept, sweepErr := p.sweepBlobs(ctx, now); sweepErr != nil || swept > 0 {
+		elog.Info("blob sweep finished", zap.Int("swept", swept), zap.Error(sweepErr))
+	}
+	return
+}
+
+// deleteCapture removes every artifact of a capture, then the capture itself from the index.
+// Its blobs are left to the orphan sweep of the gc, other captures may still reference them.
+func (p *pprof) deleteCapture(ctx context.Context, key string) error {
+	if err := p.storage.DeletePrefix(ctx, key); err != nil {
+		return fmt.Errorf("delete artifacts error: %w", err)
+	}
+	p.index.delete(key)
+	return nil
+}
+
+// PinCapture pins or unpins a capture, the retention GC keeps pinned captures.
+// Running captures are refused, their final save would drop the pin.
+func (p *pprof) PinCapture(key string, pinned bool) (*Capture, error) {
+	return p.updateCapture(context.TODO(), key, func(c *Capture) error {
+		if c.Status == CaptureStatusRunning {
+			return fmt.Errorf("capture %s is still running", c.Key)
+		}
+		c.Pinned = pinned
+		return nil
+	})
+}
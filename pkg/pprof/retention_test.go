@@ -0,0 +1,136 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	newPprof := func(t *testing.T) *pprof {
+		p := newTestPprof(t)
+		add := func(pod string, age time.Duration, size int64, pinned bool) {
+			c := &Capture{
+				Key:         fmt.Sprintf("c/ns/%s_%d", pod, now.Add(-age).UnixMilli()),
+				ClusterName: "c",
+				Namespace:   "ns",
+				PodName:     pod,
+				Status:      CaptureStatusSuccess,
+				Ctime:       now.Add(-age).Unix(),
+				Size:        size,
+				Pinned:      pinned,
+			}
+			if err := p.storage.PutBytes(ctx, c.Key+"/heap.bin", []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.saveCapture(ctx, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		add("a", 1*time.Hour, 10, false)
+		add("a", 2*time.Hour, 10, false)
+		add("a", 3*time.Hour, 10, false)
+		add("b", 48*time.Hour, 10, false)
+		add("b", 72*time.Hour, 10, true)
+		return p
+	}
+	tests := []struct {
+		name    string
+		policy  RetentionPolicy
+		remains []string
+	}{
+		{"max age", RetentionPolicy{MaxAge: 24 * time.Hour, KeepPinned: true}, []string{"a-1h", "a-2h", "a-3h", "b-72h"}},
+		{"max age without keep pinned", RetentionPolicy{MaxAge: 24 * time.Hour}, []string{"a-1h", "a-2h", "a-3h"}},
+		{"max per pod", RetentionPolicy{MaxPerPod: 1, KeepPinned: true}, []string{"a-1h", "b-48h", "b-72h"}},
+		{"max total bytes", RetentionPolicy{MaxTotalBytes: 30, KeepPinned: true}, []string{"a-1h", "a-2h", "b-72h"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPprof(t)
+			if _, err := p.GC(ctx, tt.policy, now); err != nil {
+				t.Fatal(err)
+			}
+			var remains []string
+			for _, c := range p.index.list(nil) {
+				remains = append(remains, fmt.Sprintf("%s-%dh", c.PodName, (now.Unix()-c.Ctime)/3600))
+				if _, err := p.storage.GetBytes(ctx, c.Key+"/heap.bin"); err != nil {
+					t.Errorf("artifact of kept capture %s removed: %v", c.Key, err)
+				}
+			}
+			sort.Strings(remains)
+			if fmt.Sprint(remains) != fmt.Sprint(tt.remains) {
+				t.Errorf("remains %v, want %v", remains, tt.remains)
+			}
+			names, _ := p.storage.List(ctx, "c/ns")
+			if len(names) != len(tt.remains) {
+				t.Errorf("storage keeps %d captures, want %d", len(names), len(tt.remains))
+			}
+		})
+	}
+}
+
+func TestPinCapture(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	c := &Capture{Key: "c/ns/pod_1", Status: CaptureStatusRunning}
+	if err := p.saveCapture(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	// the final save of a running capture would drop the pin
+	if _, err := p.PinCapture(c.Key, true); err == nil {
+		t.Error("running capture pinned")
+	}
+	c.Status = CaptureStatusSuccess
+	if err := p.saveCapture(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.PinCapture(c.Key, true); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := p.loadCapture(ctx, c.Key)
+	if err != nil || !reloaded.Pinned {
+		t.Errorf("reloaded capture = %+v, %v, want pinned", reloaded, err)
+	}
+}
+
+func TestGCRenderedBytes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	p := newTestPprof(t)
+	var older *Capture
+	for i, age := range []time.Duration{2 * time.Hour, time.Hour} {
+		raw := []byte(fmt.Sprintf("profile %d", i))
+		c := newTestCapture(t, p, fmt.Sprintf("c/ns/pod_%d", now.Add(-age).UnixMilli()), "heap", raw)
+		c, err := p.updateCapture(ctx, c.Key, func(c *Capture) error {
+			c.Ctime = now.Add(-age).Unix()
+			c.Profiles = map[string]ProfileRef{"heap": {Hash: c.Profiles["heap"].Hash, Size: int64(len(raw))}}
+			c.Size = int64(len(raw))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if older == nil {
+			older = c
+		}
+	}
+	// a graph rendered after the capture was saved records its bytes
+	if err := p.storeRender(ctx, older, "heap", path.Join(blobDir(older.Profiles["heap"].Hash), "flame.svg"), make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := p.GetCapture(older.Key); c.Profiles["heap"].Size != older.Size+100 || c.Size != older.Size+100 {
+		t.Fatalf("sizes after render = %d, %d, want %d", c.Profiles["heap"].Size, c.Size, older.Size+100)
+	}
+	deleted, err := p.GC(ctx, RetentionPolicy{MaxTotalBytes: 50}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(deleted) != fmt.Sprint([]string{older.Key}) {
+		t.Errorf("GC() deleted %v, want the capture with the rendered graph %s", deleted, older.Key)
+	}
+}
@@ -0,0 +1,273 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/pprof/driver"
+	"github.com/google/pprof/profile"
+	"github.com/gotomicro/ego/core/econf"
+
+	"goprobe/pkg/dto"
+	"goprobe/pkg/storage"
+)
+
+const (
+	SourceViewList   = "list"
+	SourceViewDisasm = "disasm"
+)
+
+// binaryName is the executable of the target attached to a capture, stored as <Key>/binary.
+const binaryName = "binary"
+
+// GetSource annotates the functions matching req.Func with the samples of a stored profile,
+// per source line like go tool pprof -list or per instruction like -disasm.
+// Sources are looked up in source.paths only, disassembly needs the binary attached to the capture.
+func (p *pprof) GetSource(req dto.ReqGetSource) ([]byte, error) {
+	switch req.View {
+	case "":
+		req.View = SourceViewList
+	case SourceViewList, SourceViewDisasm:
+	default:
+		return nil, fmt.Errorf("view (%s) isn't supported", req.View)
+	}
+	if _, err := regexp.Compile(req.Func); err != nil {
+		return nil, fmt.Errorf("invalid func regexp: %w", err)
+	}
+	if req.SampleIndex != "" && !sampleIndexRegexp.MatchString(req.SampleIndex) {
+		return nil, fmt.Errorf("invalid sample index %s", req.SampleIndex)
+	}
+	c, err := p.GetCapture(req.Url)
+	if err != nil {
+		return nil, err
+	}
+	if !containsString(c.Kinds, req.GoType) {
+		return nil, fmt.Errorf("capture %s has no %s profile: %w", c.Key, req.GoType, storage.ErrNotFound)
+	}
+	if req.View == SourceViewDisasm && c.Binary == "" {
+		return nil, fmt.Errorf("disasm needs the binary of the target, attach it to capture %s first", c.Key)
+	}
+
+	args := []string{"-symbolize=none", "-output=report", "-" + req.View + "=" + req.Func}
+	if req.SampleIndex != "" {
+		args = append(args, "-sample_index="+req.SampleIndex)
+	}
+	if c.Binary != "" {
+		exe, err := p.fetchBinary(context.TODO(), c)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(filepath.Dir(exe))
+		// the binary replaces the executable mapping of the profile, whose file is a path in the target
+		args = append(args, exe)
+	}
+	args = append(args, path.Join(c.Key, req.GoType))
+
+	out := &reportWriter{}
+	err = runDriver(&driver.Options{
+		Writer: out,
+		Fetch:  &storageFetcher{p: p, c: c, kind: req.GoType, sources: true},
+	}, args...)
+	if err != nil {
+		return nil, fmt.Errorf("pprof -%s error: %w", req.View, err)
+	}
+	return out.Bytes(), nil
+}
+
+// noTrimPath is a -trim_path no file name starts with.
+const noTrimPath = "/\x00"
+
+// confineSources points the source files of prof at the files found in source.paths and clears the
+// others. The driver opens absolute names as is and looks relative ones up in every parent of its
+// source path, profiles are untrusted and must not read files of the goprobe host.
+func confineSources(prof *profile.Profile) {
+	roots := sourceRoots()
+	trims := econf.GetStringSlice("source.trimPaths")
+	resolved := make(map[string]string)
+	for _, fn := range prof.Function {
+		if fn.Filename == "" {
+			continue
+		}
+		name, ok := resolved[fn.Filename]
+		if !ok {
+			name = resolveSource(fn.Filename, roots, trims)
+			resolved[fn.Filename] = name
+		}
+		fn.Filename = name
+	}
+}
+
+// confineMappings clears the file names of the mappings of prof. The driver opens them as binaries for
+// disassembly when they aren't found in $PPROF_BINARY_PATH, where build IDs must stay a single path element.
+func confineMappings(prof *profile.Profile) {
+	for _, m := range prof.Mapping {
+		m.File = ""
+		if strings.ContainsAny(m.BuildID, `/\`) || m.BuildID == "." || m.BuildID == ".." {
+			m.BuildID = ""
+		}
+	}
+}
+
+// sourceRoots returns the absolute paths of source.paths with their symlinks evaluated.
+func sourceRoots() []string {
+	var roots []string
+	for _, dir := range econf.GetStringSlice("source.paths") {
+		if dir == "" {
+			continue
+		}
+		dir, err := filepath.Abs(dir)
+		if err == nil {
+			dir, err = filepath.EvalSymlinks(dir)
+		}
+		if err == nil {
+			roots = append(roots, dir)
+		}
+	}
+	return roots
+}
+
+// resolveSource finds the source file name of a profile in roots, like go tool pprof -source_path and
+// -trim_path without searching the parents of roots. It returns "" when no root has it.
+func resolveSource(name string, roots, trims []string) string {
+	name = filepath.ToSlash(name)
+	var rels []string
+	for _, trim := range trims {
+		if trim = strings.TrimSuffix(filepath.ToSlash(trim), "/") + "/"; trim != "/" && strings.HasPrefix(name, trim) {
+			rels = append(rels, name[len(trim):])
+		}
+	}
+	for _, root := range roots {
+		// names built elsewhere often contain the checkout of root, e.g. /build/src/app/main.go for root /src/app
+		if i := strings.Index(name, "/"+filepath.Base(root)+"/"); i >= 0 {
+			rels = append(rels, name[i+len(filepath.Base(root))+2:])
+		}
+	}
+	for _, root := range roots {
+		if path.IsAbs(name) && insideDir(root, filepath.FromSlash(name)) {
+			if file, ok := sourceFile(root, filepath.FromSlash(name)); ok {
+				return file
+			}
+		}
+		for _, rel := range append(rels, name) {
+			if path.IsAbs(rel) {
+				continue
+			}
+			if file, ok := sourceFile(root, filepath.Join(root, filepath.FromSlash(rel))); ok {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// sourceFile returns name if it is a regular file that stays in root once its symlinks are evaluated.
+func sourceFile(root, name string) (string, bool) {
+	if !insideDir(root, name) {
+		return "", false
+	}
+	real, err := filepath.EvalSymlinks(name)
+	if err != nil || !insideDir(root, real) {
+		return "", false
+	}
+	if info, err := os.Stat(real); err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return real, true
+}
+
+// insideDir reports whether the clean path name is dir or below it.
+func insideDir(dir, name string) bool {
+	rel, err := filepath.Rel(dir, filepath.Clean(name))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// fetchBinary copies the binary attached to c into a temp dir, the driver opens binaries by path.
+func (p *pprof) fetchBinary(ctx context.Context, c *Capture) (string, error) {
+	r, err := p.storage.Get(ctx, path.Join(c.Key, binaryName))
+	if err != nil {
+		return "", fmt.Errorf("read binary error: %w", err)
+	}
+	defer r.Close()
+	dir, err := ioutil.TempDir("", "goprobe-binary-")
+	if err != nil {
+		return "", err
+	}
+	exe := filepath.Join(dir, c.Binary)
+	f, err := os.OpenFile(exe, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err == nil {
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("write binary error: %w", err)
+	}
+	return exe, nil
+}
+
+// AttachBinary stores the executable of the target of capture key, it is used to disassemble
+// and symbolize the profiles of the capture. An attached binary is replaced.
+func (p *pprof) AttachBinary(key, name string, r io.Reader, operator string) (*Capture, error) {
+	name = filepath.Base(name)
+	if !uploadNameRegexp.MatchString(name) || strings.HasPrefix(name, ".") {
+		name = binaryName
+	}
+	ctx := context.TODO()
+	c, err := p.GetCapture(key)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status == CaptureStatusRunning {
+		return nil, fmt.Errorf("capture %s is still running", c.Key)
+	}
+	var oldSize int64
+	if c.Binary != "" {
+		if info, err := p.storage.Stat(ctx, path.Join(c.Key, binaryName)); err == nil {
+			oldSize = info.Size
+		}
+	}
+	cr := &countingReader{r: r}
+	if err = p.storage.Put(ctx, path.Join(c.Key, binaryName), cr); err != nil {
+		return nil, fmt.Errorf("save binary error: %w", err)
+	}
+	c, err = p.updateCapture(ctx, c.Key, func(c *Capture) error {
+		c.Binary = name
+		c.Size += cr.n - oldSize
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.audit(ctx, AuditRecord{Operator: operator, Action: AuditActionUpdate, Keys: []string{c.Key}, Detail: "binary " + name})
+	return c, nil
+}
+
+// reportWriter collects the report the driver writes to its -output.
+type reportWriter struct {
+	bytes.Buffer
+}
+
+func (w *reportWriter) Open(string) (io.WriteCloser, error) { return w, nil }
+
+func (w *reportWriter) Close() error { return nil }
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += int64(n)
+	return n, err
+}
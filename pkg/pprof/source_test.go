@@ -0,0 +1,112 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gotomicro/ego/core/econf"
+
+	"goprobe/pkg/dto"
+)
+
+func TestGetSource(t *testing.T) {
+	p := newTestPprof(t)
+	// point the functions of the test profile at a local source file
+	var src strings.Builder
+	for i := 1; i <= 25; i++ {
+		switch i {
+		case 10:
+			src.WriteString("\twork()\n")
+		case 20:
+			src.WriteString("\tspin()\n")
+		default:
+			src.WriteString("\t// filler\n")
+		}
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	econf.Set("source.paths", []string{dir})
+	econf.Set("source.trimPaths", []string{"/build"})
+	defer econf.Set("source.paths", []string{})
+	defer econf.Set("source.trimPaths", []string{})
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfileSource(t, "/build/main.go"))
+
+	out, err := p.GetSource(dto.ReqGetSource{Url: c.Key, GoType: "profile", Func: "main.work", SampleIndex: "samples"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line string
+	for _, l := range strings.Split(string(out), "\n") {
+		if strings.Contains(l, "spin()") {
+			line = l
+		}
+	}
+	if fields := strings.Fields(line); len(fields) < 3 || fields[0] != "3" || fields[1] != "3" || fields[2] != "20:" {
+		t.Errorf("annotated line of spin() = %q, want flat and cum 3 at line 20\n%s", line, out)
+	}
+
+	if _, err = p.GetSource(dto.ReqGetSource{Url: c.Key, GoType: "profile", Func: "main.work", View: SourceViewDisasm}); err == nil {
+		t.Error("disasm without a binary should fail")
+	}
+	if _, err = p.GetSource(dto.ReqGetSource{Url: c.Key, GoType: "profile", Func: "("}); err == nil {
+		t.Error("invalid func regexp should fail")
+	}
+}
+
+func TestGetSourceOutsidePaths(t *testing.T) {
+	p := newTestPprof(t)
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret.go")
+	if err := os.WriteFile(secret, []byte(strings.Repeat("TOPSECRET\n", 25)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "src")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	econf.Set("source.paths", []string{root})
+	defer econf.Set("source.paths", []string{})
+
+	for i, name := range []string{"/etc/passwd", secret, "../secret.go", "src/../../secret.go", "link.go", "etc/passwd"} {
+		c := newTestCapture(t, p, "c/ns/pod_"+strconv.Itoa(i), "profile", testProfileSource(t, name))
+		out, err := p.GetSource(dto.ReqGetSource{Url: c.Key, GoType: "profile", Func: "main.work"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(out), "TOPSECRET") || strings.Contains(string(out), ":x:") {
+			t.Errorf("list of a profile naming %s read a file outside source.paths:\n%s", name, out)
+		}
+	}
+}
+
+func TestAttachBinary(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPprof(t)
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+
+	for i, size := range []int{100, 40} {
+		got, err := p.AttachBinary(c.Key, "../server", bytes.NewReader(make([]byte, size)), "tester")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a replaced binary doesn't count twice
+		if got.Binary != "server" || got.Size != int64(size) {
+			t.Errorf("attach %d: binary %s size %d, want server %d", i, got.Binary, got.Size, size)
+		}
+	}
+	data, err := p.storage.GetBytes(ctx, path.Join(c.Key, binaryName))
+	if err != nil || len(data) != 40 {
+		t.Errorf("stored binary: %d bytes, %v", len(data), err)
+	}
+}
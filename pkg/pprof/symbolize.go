@@ -0,0 +1,181 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/pprof/driver"
+	"github.com/google/pprof/profile"
+	"github.com/gotomicro/ego/core/econf"
+
+	"goprobe/pkg/dto"
+	"goprobe/pkg/storage"
+)
+
+var buildIDRegexp = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// mainMapping is the mapping of the executable of a profile, Go profiles list it first.
+func mainMapping(prof *profile.Profile) *profile.Mapping {
+	if len(prof.Mapping) == 0 {
+		return nil
+	}
+	return prof.Mapping[0]
+}
+
+// profileBuildID returns the build ID of the executable prof was taken from, if recorded.
+func profileBuildID(prof *profile.Profile) string {
+	if m := mainMapping(prof); m != nil {
+		return m.BuildID
+	}
+	return ""
+}
+
+// findBinary looks up the executable with buildID in symbolize.binaryDir, stored as
+// <dir>/<build ID>/<file name> or <dir>/<build ID> like PPROF_BINARY_PATH.
+func findBinary(buildID, file string) (string, bool) {
+	dir := econf.GetString("symbolize.binaryDir")
+	if dir == "" || !buildIDRegexp.MatchString(buildID) {
+		return "", false
+	}
+	candidates := []string{filepath.Join(dir, buildID)}
+	if base := path.Base(filepath.ToSlash(file)); file != "" && base != "." && base != "/" {
+		candidates = append([]string{filepath.Join(dir, buildID, base)}, candidates...)
+	}
+	for _, name := range candidates {
+		if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Symbolize resolves the addresses of the profiles of a capture with the binary of the target
+// and stores the symbolized profiles as new blobs, their graphs are rendered on request. The binary is looked up by the
+// build ID of each profile in symbolize.binaryDir, falling back to the binary attached to the capture.
+// Kinds are updated one at a time, the ones done before an error stay symbolized and are audited.
+func (p *pprof) Symbolize(req dto.ReqSymbolize, operator string) (*Capture, error) {
+	ctx := context.TODO()
+	c, err := p.GetCapture(req.Url)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status == CaptureStatusRunning {
+		return nil, fmt.Errorf("capture %s is still running", c.Key)
+	}
+	kinds := req.Kinds
+	if len(kinds) == 0 {
+		kinds = c.Kinds
+	}
+	var attached string
+	var symbolized []string
+	key := c.Key
+	defer func() {
+		if len(symbolized) > 0 {
+			p.audit(ctx, AuditRecord{Operator: operator, Action: AuditActionUpdate, Keys: []string{key}, Detail: "symbolize " + strings.Join(symbolized, ",")})
+		}
+	}()
+	for _, kind := range kinds {
+		ref, ok := c.Profiles[kind]
+		if !ok {
+			return nil, fmt.Errorf("capture %s has no %s profile or was stored before deduplication: %w", c.Key, kind, storage.ErrNotFound)
+		}
+		raw, err := p.storage.GetBytes(ctx, path.Join(blobDir(ref.Hash), blobRawName))
+		if err != nil {
+			return nil, err
+		}
+		prof, err := profile.ParseData(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s profile error: %w", kind, err)
+		}
+		var buildID, file string
+		if m := mainMapping(prof); m != nil {
+			buildID, file = m.BuildID, m.File
+		}
+		exe, ok := findBinary(buildID, file)
+		if !ok && c.Binary != "" {
+			if attached == "" {
+				if attached, err = p.fetchBinary(ctx, c); err != nil {
+					return nil, err
+				}
+				defer os.RemoveAll(filepath.Dir(attached))
+			}
+			exe, ok = attached, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("no binary with build id %q for the %s profile, attach it to the capture or add it to symbolize.binaryDir", buildID, kind)
+		}
+
+		data, err := p.symbolizeProfile(c, kind, exe, file)
+		if err != nil {
+			return nil, err
+		}
+		hash := hashProfile(data)
+		if hash == ref.Hash {
+			continue
+		}
+		p.blobs.acquire(hash)
+		defer p.blobs.release(hash)
+		size, err := p.saveRaw(data, hash, kind)
+		if err != nil {
+			return nil, err
+		}
+		c, err = p.updateCapture(ctx, c.Key, func(c *Capture) error {
+			// the maps are shared with the index until saved
+			profiles := make(map[string]ProfileRef, len(c.Profiles))
+			for k, v := range c.Profiles {
+				profiles[k] = v
+			}
+			profiles[kind] = ProfileRef{Hash: hash, Size: size}
+			c.Size += size - c.Profiles[kind].Size
+			c.Profiles = profiles
+			if buildID != "" {
+				buildIDs := map[string]string{kind: buildID}
+				for k, v := range c.BuildIDs {
+					if k != kind {
+						buildIDs[k] = v
+					}
+				}
+				c.BuildIDs = buildIDs
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		// the unsymbolized blob is left to the orphan sweep of the gc
+		symbolized = append(symbolized, kind)
+	}
+	return c, nil
+}
+
+// symbolizeProfile runs the local symbolizer of pprof over a stored profile with the executable exe
+// standing in for file, the one of the target, and returns the symbolized profile.
+func (p *pprof) symbolizeProfile(c *Capture, kind, exe, file string) ([]byte, error) {
+	out := &reportWriter{}
+	err := runDriver(&driver.Options{
+		Writer: out,
+		Fetch:  &storageFetcher{p: p, c: c, kind: kind},
+	}, "-symbolize=local", "-proto", "-output=profile", exe, path.Join(c.Key, kind))
+	if err != nil {
+		return nil, fmt.Errorf("symbolize %s profile error: %w", kind, err)
+	}
+	prof, err := profile.Parse(out)
+	if err != nil {
+		return nil, fmt.Errorf("symbolize %s profile error: %w", kind, err)
+	}
+	// keep the path of the executable in the target instead of the temp copy
+	if m := mainMapping(prof); m != nil && file != "" {
+		m.File = file
+	}
+	var buf bytes.Buffer
+	if err = prof.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
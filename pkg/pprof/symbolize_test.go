@@ -0,0 +1,157 @@
+package pprof
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/gotomicro/ego/core/econf"
+
+	"goprobe/pkg/dto"
+)
+
+func TestFindBinary(t *testing.T) {
+	dir := t.TempDir()
+	econf.Set("symbolize.binaryDir", dir)
+	defer econf.Set("symbolize.binaryDir", "")
+	for _, name := range []string{"abc123/server", "def456"} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("elf"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		buildID, file, want string
+	}{
+		{"abc123", "/app/server", "abc123/server"},
+		{"def456", "/app/server", "def456"},
+		{"abc123", "/app/other", ""},
+		{"../def456", "/app/server", ""},
+		{"", "/app/server", ""},
+	} {
+		got, ok := findBinary(tc.buildID, tc.file)
+		if want := filepath.Join(dir, filepath.FromSlash(tc.want)); ok != (tc.want != "") || ok && got != want {
+			t.Errorf("findBinary(%s, %s) = %s %v, want %s", tc.buildID, tc.file, got, ok, tc.want)
+		}
+	}
+}
+
+// symbolizeTarget writes its goroutine profile to the file named by its argument.
+const symbolizeTarget = `package main
+
+import (
+	"os"
+	"runtime/pprof"
+)
+
+func main() {
+	f, err := os.Create(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if err = pprof.Lookup("goroutine").WriteTo(f, 0); err != nil {
+		panic(err)
+	}
+}
+`
+
+func TestSymbolizeProfile(t *testing.T) {
+	if testing.Short() || runtime.GOOS != "linux" {
+		t.Skip("builds an ELF target")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go isn't installed")
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{"go.mod": "module target\n", "main.go": symbolizeTarget} {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exe := filepath.Join(dir, "target")
+	build := exec.Command(goBin, "build", "-o", exe, ".")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build target: %v\n%s", err, out)
+	}
+	if out, err := exec.Command(exe, filepath.Join(dir, "goroutine.pb.gz")).CombinedOutput(); err != nil {
+		t.Fatalf("run target: %v\n%s", err, out)
+	}
+	f, err := os.Open(filepath.Join(dir, "goroutine.pb.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof, err := profile.Parse(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// strip the profile to addresses, like the ones of stripped binaries
+	for _, loc := range prof.Location {
+		loc.Line = nil
+	}
+	prof.Function = nil
+	for _, m := range prof.Mapping {
+		m.HasFunctions, m.HasFilenames, m.HasLineNumbers, m.HasInlineFrames = false, false, false, false
+	}
+	mainMapping(prof).File = "/app/target"
+	var raw bytes.Buffer
+	if err = prof.Write(&raw); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPprof(t)
+	c := newTestCapture(t, p, "c/ns/pod_1", "goroutine", raw.Bytes())
+
+	data, err := p.symbolizeProfile(c, "goroutine", exe, "/app/target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := profile.ParseData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, fn := range got.Function {
+		found = found || fn.Name == "main.main"
+	}
+	if !found {
+		t.Errorf("main.main not symbolized, functions: %v", got.Function)
+	}
+	if file := got.Mapping[0].File; file != "/app/target" {
+		t.Errorf("mapping file %s, want /app/target", file)
+	}
+
+	// a kind failing after another was symbolized leaves an audit record of the one done
+	f, err = os.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.AttachBinary(c.Key, "target", f, "alice")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Symbolize(dto.ReqSymbolize{Url: c.Key, Kinds: []string{"goroutine", "heap"}}, "alice"); err == nil {
+		t.Fatal("Symbolize() of a missing kind succeeded")
+	}
+	if c, _ = p.GetCapture(c.Key); c.Profiles["goroutine"].Hash == hashProfile(raw.Bytes()) {
+		t.Error("goroutine profile wasn't symbolized")
+	}
+	records, err := p.ListAudit(time.Now().Format("2006-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || records[0].Detail != "symbolize goroutine" {
+		t.Errorf("audit records %+v, want the goroutine symbolization", records)
+	}
+}
@@ -0,0 +1,212 @@
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+
+	"goprobe/pkg/dto"
+)
+
+const (
+	TopGranularityFunctions = "functions"
+	TopGranularityLines     = "lines"
+	TopGranularityPackages  = "packages"
+
+	defaultTopN = 20
+	maxTopN     = 1000
+)
+
+// TopSummary is the go tool pprof -top table of a profile plus its totals.
+type TopSummary struct {
+	SampleTypes   []SampleType `json:"sampleTypes"`
+	SampleIndex   string       `json:"sampleIndex"` // the sample type the table is computed from
+	Unit          string       `json:"unit"`
+	Total         int64        `json:"total"`
+	Samples       int          `json:"samples"`
+	DurationNanos int64        `json:"durationNanos"`
+	TimeNanos     int64        `json:"timeNanos"` // capture time of the profile
+	Granularity   string       `json:"granularity"`
+	Rows          []TopRow     `json:"rows"`
+}
+
+type SampleType struct {
+	Type string `json:"type"`
+	Unit string `json:"unit"`
+}
+
+type TopRow struct {
+	Name    string  `json:"name"`
+	Flat    int64   `json:"flat"`
+	FlatPct float64 `json:"flatPct"`
+	Cum     int64   `json:"cum"`
+	CumPct  float64 `json:"cumPct"`
+}
+
+// GetTop computes the top table of a stored profile.
+func (p *pprof) GetTop(req dto.ReqGetTop) (*TopSummary, error) {
+	if req.N <= 0 {
+		req.N = defaultTopN
+	}
+	if req.N > maxTopN {
+		req.N = maxTopN
+	}
+	switch req.Granularity {
+	case "":
+		req.Granularity = TopGranularityFunctions
+	case TopGranularityFunctions, TopGranularityLines, TopGranularityPackages:
+	default:
+		return nil, fmt.Errorf("granularity (%s) isn't supported", req.Granularity)
+	}
+	c, err := p.GetCapture(req.Url)
+	if err != nil {
+		return nil, err
+	}
+	prof, err := p.parseProfile(context.TODO(), c, req.GoType)
+	if err != nil {
+		return nil, err
+	}
+	index, err := defaultSampleIndex(prof, req.SampleIndex)
+	if err != nil {
+		return nil, err
+	}
+	return topSummary(prof, index, req.Granularity, req.N), nil
+}
+
+// parseProfile reads and parses the raw profile of a capture kind.
+func (p *pprof) parseProfile(ctx context.Context, c *Capture, kind string) (*profile.Profile, error) {
+	r, err := p.openRaw(ctx, c, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	prof, err := profile.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("parse profile error: %w", err)
+	}
+	return prof, nil
+}
+
+// defaultSampleIndex resolves sampleIndex like go tool pprof, the profile default or the last sample type when empty.
+func defaultSampleIndex(prof *profile.Profile, sampleIndex string) (int, error) {
+	if len(prof.SampleType) == 0 {
+		return 0, fmt.Errorf("profile has no sample types")
+	}
+	if sampleIndex == "" {
+		sampleIndex = prof.DefaultSampleType
+	}
+	if sampleIndex == "" {
+		return len(prof.SampleType) - 1, nil
+	}
+	return findSampleType(prof, sampleIndex)
+}
+
+func topSummary(prof *profile.Profile, index int, granularity string, n int) *TopSummary {
+	summary := &TopSummary{
+		SampleIndex:   prof.SampleType[index].Type,
+		Unit:          prof.SampleType[index].Unit,
+		Samples:       len(prof.Sample),
+		DurationNanos: prof.DurationNanos,
+		TimeNanos:     prof.TimeNanos,
+		Granularity:   granularity,
+		Rows:          make([]TopRow, 0),
+	}
+	for _, st := range prof.SampleType {
+		summary.SampleTypes = append(summary.SampleTypes, SampleType{Type: st.Type, Unit: st.Unit})
+	}
+
+	rows := make(map[string]*TopRow)
+	row := func(name string) *TopRow {
+		r, ok := rows[name]
+		if !ok {
+			r = &TopRow{Name: name}
+			rows[name] = r
+		}
+		return r
+	}
+	for _, s := range prof.Sample {
+		v := s.Value[index]
+		if v == 0 {
+			continue
+		}
+		summary.Total += v
+		seen := make(map[string]bool)
+		leaf := true
+		for _, loc := range s.Location {
+			// Line[0] is the innermost frame of inlined calls
+			for _, line := range loc.Line {
+				name := frameName(line, granularity)
+				if leaf {
+					row(name).Flat += v
+					leaf = false
+				}
+				if !seen[name] {
+					seen[name] = true
+					row(name).Cum += v
+				}
+			}
+		}
+	}
+
+	list := make([]TopRow, 0, len(rows))
+	for _, r := range rows {
+		if summary.Total != 0 {
+			r.FlatPct = percent(r.Flat, summary.Total)
+			r.CumPct = percent(r.Cum, summary.Total)
+		}
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Flat != list[j].Flat {
+			return abs(list[i].Flat) > abs(list[j].Flat)
+		}
+		if list[i].Cum != list[j].Cum {
+			return abs(list[i].Cum) > abs(list[j].Cum)
+		}
+		return list[i].Name < list[j].Name
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	summary.Rows = list
+	return summary
+}
+
+func frameName(line profile.Line, granularity string) string {
+	name := "<unknown>"
+	if line.Function != nil {
+		name = line.Function.Name
+	}
+	switch granularity {
+	case TopGranularityLines:
+		if line.Function != nil && line.Function.Filename != "" {
+			return fmt.Sprintf("%s %s:%d", name, line.Function.Filename, line.Line)
+		}
+	case TopGranularityPackages:
+		return packageName(name)
+	}
+	return name
+}
+
+// packageName returns the import path of a Go function name, e.g. net/http for net/http.(*Server).Serve.
+func packageName(name string) string {
+	n := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[n+1:], "."); dot >= 0 {
+		return name[:n+1+dot]
+	}
+	return name
+}
+
+func percent(v, total int64) float64 {
+	return float64(int64(float64(v)/float64(total)*10000)) / 100
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
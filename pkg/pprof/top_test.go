@@ -0,0 +1,58 @@
+package pprof
+
+import (
+	"testing"
+
+	"goprobe/pkg/dto"
+)
+
+func TestGetTop(t *testing.T) {
+	p := newTestPprof(t)
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+
+	top, err := p.GetTop(dto.ReqGetTop{Url: c.Key, GoType: "profile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last sample type is the default
+	if top.SampleIndex != "cpu" || top.Total != 40000000 || top.Samples != 2 || len(top.SampleTypes) != 2 {
+		t.Errorf("unexpected summary %+v", top)
+	}
+	want := []TopRow{
+		{Name: "main.work", Flat: 30000000, FlatPct: 75, Cum: 30000000, CumPct: 75},
+		{Name: "main.main", Flat: 10000000, FlatPct: 25, Cum: 40000000, CumPct: 100},
+	}
+	if len(top.Rows) != len(want) || top.Rows[0] != want[0] || top.Rows[1] != want[1] {
+		t.Errorf("rows = %+v, want %+v", top.Rows, want)
+	}
+
+	top, err = p.GetTop(dto.ReqGetTop{Url: c.Key, GoType: "profile", SampleIndex: "samples", Granularity: TopGranularityLines, N: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top.Rows) != 1 || top.Rows[0].Name != "main.work main.go:20" || top.Rows[0].Flat != 3 {
+		t.Errorf("lines rows = %+v", top.Rows)
+	}
+
+	top, err = p.GetTop(dto.ReqGetTop{Url: c.Key, GoType: "profile", Granularity: TopGranularityPackages})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top.Rows) != 1 || top.Rows[0].Name != "main" || top.Rows[0].FlatPct != 100 {
+		t.Errorf("packages rows = %+v", top.Rows)
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	for name, want := range map[string]string{
+		"main.main":                       "main",
+		"net/http.(*Server).Serve":        "net/http",
+		"github.com/a/b.c/d.Func.func1":   "github.com/a/b.c/d",
+		"runtime.gcBgMarkWorker":          "runtime",
+		"google.golang.org/grpc.(*S).Run": "google.golang.org/grpc",
+	} {
+		if got := packageName(name); got != want {
+			t.Errorf("packageName(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
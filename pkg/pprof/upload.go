@@ -0,0 +1,97 @@
+package pprof
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"goprobe/pkg/dto"
+)
+
+// uploadKinds are the profile kinds accepted from uploads.
+var uploadKinds = map[string]bool{
+	"block":        true,
+	"goroutine":    true,
+	"heap":         true,
+	"profile":      true,
+	"allocs":       true,
+	"mutex":        true,
+	"threadcreate": true,
+}
+
+var uploadNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._:-]+$`)
+
+// UploadFile is one uploaded profile of a declared kind.
+type UploadFile struct {
+	Kind string
+	Data []byte
+}
+
+// UploadProfiles validates externally captured profiles, stores them under a capture key
+// like live captures and renders them.
+func (p *pprof) UploadProfiles(req dto.ReqUploadProfile, files []UploadFile) (list []PprofInfo, err error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no profile uploaded")
+	}
+	if req.Name == "" {
+		req.Name = "upload"
+	}
+	if !uploadNameRegexp.MatchString(req.Name) {
+		return nil, fmt.Errorf("invalid name %s, only letters, digits and ._:- are allowed", req.Name)
+	}
+	if req.ClusterName != "" && !validClusterName(req.ClusterName) {
+		return nil, fmt.Errorf("invalid cluster name %s", req.ClusterName)
+	}
+	labels, err := ParseLabels(req.Labels)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]byte, len(files))
+	kinds := make([]string, 0, len(files))
+	for _, f := range files {
+		if !uploadKinds[f.Kind] {
+			return nil, fmt.Errorf("profile kind (%s) isn't supported", f.Kind)
+		}
+		if _, ok := data[f.Kind]; ok {
+			return nil, fmt.Errorf("profile kind (%s) uploaded twice", f.Kind)
+		}
+		if err = validateProfile(f.Data); err != nil {
+			return nil, fmt.Errorf("invalid %s profile: %w", f.Kind, err)
+		}
+		data[f.Kind] = f.Data
+		kinds = append(kinds, f.Kind)
+	}
+	sort.Strings(kinds)
+
+	now := time.Now()
+	capture := newCapture(dto.ReqRunProfile{
+		Mode:        ProfileRunTypeUpload,
+		ClusterName: req.ClusterName,
+		Namespace:   uploadNamespace,
+		PodName:     req.Name,
+		Operator:    req.Operator,
+		TriggeredBy: TriggerManual,
+		UniqueKey:   strings.TrimPrefix(fmt.Sprintf("%s/%s/%s_%d", req.ClusterName, uploadNamespace, req.Name, now.UnixMilli()), "/"),
+	}, now)
+	capture.Labels = labels
+	return p.runCapture(capture, kinds, func(profileType string, _ map[string]string) ([]byte, error) {
+		return data[profileType], nil
+	})
+}
+
+// validateProfile checks that data is a well formed pprof protobuf, gzipped or not.
+func validateProfile(data []byte) error {
+	prof, err := profile.Parse(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if len(prof.SampleType) == 0 {
+		return fmt.Errorf("profile has no sample types")
+	}
+	return prof.CheckValid()
+}
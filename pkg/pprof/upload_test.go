@@ -0,0 +1,57 @@
+package pprof
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/pprof/profile"
+
+	"goprobe/pkg/dto"
+)
+
+func TestValidateProfile(t *testing.T) {
+	fn := &profile.Function{ID: 1, Name: "main.main"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{1}}},
+		Location:   []*profile.Location{loc},
+		Function:   []*profile.Function{fn},
+	}
+	var buf bytes.Buffer
+	if err := prof.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateProfile(buf.Bytes()); err != nil {
+		t.Errorf("valid profile rejected: %v", err)
+	}
+	if err := validateProfile([]byte("not a profile")); err == nil {
+		t.Error("invalid profile accepted")
+	}
+}
+
+func TestUploadProfilesCluster(t *testing.T) {
+	p := newTestPprof(t)
+	files := []UploadFile{{Kind: "profile", Data: testProfile(t)}}
+	for _, cluster := range []string{"..", "../x", "a/b", "_blobs", ".hidden", "upload", "custom"} {
+		if _, err := p.UploadProfiles(dto.ReqUploadProfile{ClusterName: cluster, Name: "api"}, files); err == nil {
+			t.Errorf("cluster name %q accepted", cluster)
+		}
+	}
+
+	if _, err := p.UploadProfiles(dto.ReqUploadProfile{Name: "api"}, files); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.UploadProfiles(dto.ReqUploadProfile{ClusterName: "saas", Name: "web"}, files); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ cluster, pod string }{{"", "api"}, {"saas", "web"}} {
+		list, err := p.GetPprofList(dto.ReqGetPprofList{ClusterName: tc.cluster, Namespace: uploadNamespace})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].PodName != tc.pod {
+			t.Errorf("GetPprofList(cluster %q) = %+v, want the upload of %s", tc.cluster, list, tc.pod)
+		}
+	}
+}
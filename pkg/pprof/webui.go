@@ -0,0 +1,226 @@
+package pprof
+
+import (
+	"container/list"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/driver"
+	"github.com/google/pprof/profile"
+	"github.com/gotomicro/ego/core/elog"
+	"go.uber.org/zap"
+)
+
+const defaultWebUICacheSize = 16
+
+// driverMu serializes driver.PProf calls, the driver keeps its settings in package level state.
+var driverMu sync.Mutex
+
+// driverResetArgs reset the sticky driver settings goprobe sets, a run would inherit them from the previous one.
+// The trim path matches no file name, an empty one lets the driver guess prefixes to strip from the names of
+// source files, which confineSources resolves itself.
+var driverResetArgs = []string{"-sample_index=", "-output=", "-trim_path=" + noTrimPath}
+
+// runDriver runs the pprof driver with fixed arguments.
+func runDriver(o *driver.Options, args ...string) error {
+	driverMu.Lock()
+	defer driverMu.Unlock()
+	o.Flagset = &uiFlags{
+		FlagSet: flag.NewFlagSet("pprof", flag.ContinueOnError),
+		args:    append(append([]string(nil), driverResetArgs...), args...),
+	}
+	if o.UI == nil {
+		o.UI = &uiLogger{}
+	}
+	return driver.PProf(o)
+}
+
+// webUIs caches the pprof web UI of recently viewed profiles, building one parses the whole profile.
+type webUIs struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List // of *webUI, most recently used first
+}
+
+type webUI struct {
+	id       string
+	handlers map[string]http.Handler
+}
+
+func newWebUIs(size int) *webUIs {
+	if size <= 0 {
+		size = defaultWebUICacheSize
+	}
+	return &webUIs{size: size, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (w *webUIs) get(id string) (*webUI, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e, ok := w.entries[id]
+	if !ok {
+		return nil, false
+	}
+	w.lru.MoveToFront(e)
+	return e.Value.(*webUI), true
+}
+
+func (w *webUIs) put(ui *webUI) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e, ok := w.entries[ui.id]; ok {
+		e.Value = ui
+		w.lru.MoveToFront(e)
+		return
+	}
+	w.entries[ui.id] = w.lru.PushFront(ui)
+	for w.lru.Len() > w.size {
+		oldest := w.lru.Back()
+		w.lru.Remove(oldest)
+		delete(w.entries, oldest.Value.(*webUI).id)
+	}
+}
+
+// ServeWebUI serves the upstream pprof web UI of a stored profile. path is <capture key>/<kind>/<view>,
+// views are the ones of go tool pprof -http: "" (graph), top, flamegraph, peek, source, disasm, download...
+func (p *pprof) ServeWebUI(w http.ResponseWriter, r *http.Request, uiPath string) {
+	c, kind, view, ok := p.resolveUIPath(strings.Trim(uiPath, "/"))
+	if !ok {
+		http.Error(w, "capture or profile kind not found", http.StatusNotFound)
+		return
+	}
+	// the UI links are relative to the profile directory
+	if view == "" && !strings.HasSuffix(r.URL.Path, "/") {
+		u := *r.URL
+		u.Path += "/"
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		return
+	}
+	ui, err := p.webUI(c, kind)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handler, ok := ui.handlers["/"+view]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// resolveUIPath splits <capture key>/<kind>/<view>, capture keys have a varying number of segments.
+func (p *pprof) resolveUIPath(uiPath string) (c *Capture, kind, view string, ok bool) {
+	parts := strings.Split(uiPath, "/")
+	for i := len(parts) - 1; i > 0; i-- {
+		c, err := p.GetCapture(strings.Join(parts[:i], "/"))
+		if err != nil || !containsString(c.Kinds, parts[i]) {
+			continue
+		}
+		return c, parts[i], strings.Join(parts[i+1:], "/"), true
+	}
+	return nil, "", "", false
+}
+
+func (p *pprof) webUI(c *Capture, kind string) (*webUI, error) {
+	id := artifactKey(c, kind, blobRawName)
+	if ui, ok := p.webUIs.get(id); ok {
+		return ui, nil
+	}
+	ui := &webUI{id: id}
+	err := runDriver(&driver.Options{
+		Fetch: &storageFetcher{p: p, c: c, kind: kind, sources: true},
+		HTTPServer: func(args *driver.HTTPServerArgs) error {
+			ui.handlers = args.Handlers
+			return nil
+		},
+	}, "-http=localhost:0", "-no_browser", "-symbolize=none", path.Join(c.Key, kind))
+	if err != nil {
+		return nil, fmt.Errorf("build pprof web ui error: %w", err)
+	}
+	if ui.handlers == nil {
+		return nil, fmt.Errorf("build pprof web ui error: no handlers")
+	}
+	p.webUIs.put(ui)
+	return ui, nil
+}
+
+// storageFetcher loads profiles from goprobe storage instead of files or urls.
+type storageFetcher struct {
+	p    *pprof
+	c    *Capture
+	kind string
+	// sources confines the source files of the profile to source.paths and clears its binary names,
+	// for the reports showing them
+	sources bool
+}
+
+func (f *storageFetcher) Fetch(src string, _, _ time.Duration) (*profile.Profile, string, error) {
+	r, err := f.p.openRaw(context.TODO(), f.c, f.kind)
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	prof, err := profile.Parse(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if f.sources {
+		confineSources(prof)
+		confineMappings(prof)
+	}
+	return prof, src, nil
+}
+
+// uiFlags feeds fixed arguments to the pprof driver.
+type uiFlags struct {
+	*flag.FlagSet
+	args       []string
+	extraUsage string
+}
+
+func (f *uiFlags) StringList(name, def, usage string) *[]*string {
+	return &[]*string{f.String(name, def, usage)}
+}
+
+func (f *uiFlags) ExtraUsage() string {
+	return f.extraUsage
+}
+
+func (f *uiFlags) AddExtraUsage(eu string) {
+	f.extraUsage += eu
+}
+
+func (f *uiFlags) Parse(usage func()) []string {
+	f.Usage = usage
+	if err := f.FlagSet.Parse(f.args); err != nil {
+		return nil
+	}
+	return f.Args()
+}
+
+// uiLogger sends the driver output to the log, there is no terminal.
+type uiLogger struct{}
+
+func (uiLogger) ReadLine(string) (string, error) { return "", fmt.Errorf("no terminal") }
+
+func (uiLogger) Print(args ...interface{}) {
+	elog.Info("pprof web ui", zap.String("msg", fmt.Sprint(args...)))
+}
+
+func (uiLogger) PrintErr(args ...interface{}) {
+	elog.Warn("pprof web ui", zap.String("msg", fmt.Sprint(args...)))
+}
+
+func (uiLogger) IsTerminal() bool { return false }
+
+func (uiLogger) WantBrowser() bool { return false }
+
+func (uiLogger) SetAutoComplete(func(string) string) {}
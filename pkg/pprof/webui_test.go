@@ -0,0 +1,181 @@
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/gotomicro/ego/core/econf"
+)
+
+// testProfile returns a small cpu profile sampling main.main and main.work.
+func testProfile(t *testing.T) []byte {
+	t.Helper()
+	mainFn := &profile.Function{ID: 1, Name: "main.main", Filename: "main.go"}
+	workFn := &profile.Function{ID: 2, Name: "main.work", Filename: "main.go"}
+	mainLoc := &profile.Location{ID: 1, Address: 0x1000, Line: []profile.Line{{Function: mainFn, Line: 10}}}
+	workLoc := &profile.Location{ID: 2, Address: 0x2000, Line: []profile.Line{{Function: workFn, Line: 20}}}
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{workLoc, mainLoc}, Value: []int64{3, 30000000}},
+			{Location: []*profile.Location{mainLoc}, Value: []int64{1, 10000000}},
+		},
+		Location: []*profile.Location{mainLoc, workLoc},
+		Function: []*profile.Function{mainFn, workFn},
+	}
+	var buf bytes.Buffer
+	if err := prof.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testProfileSource returns the test profile with its functions in the source file name.
+func testProfileSource(t *testing.T, name string) []byte {
+	t.Helper()
+	prof, err := profile.ParseData(testProfile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range prof.Function {
+		fn.Filename = name
+	}
+	var raw bytes.Buffer
+	if err = prof.Write(&raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw.Bytes()
+}
+
+// newTestCapture stores raw as the profile kind of a new capture.
+func newTestCapture(t *testing.T, p *pprof, key, kind string, raw []byte) *Capture {
+	t.Helper()
+	ctx := context.Background()
+	hash := hashProfile(raw)
+	if err := p.storage.PutBytes(ctx, path.Join(blobDir(hash), blobRawName), raw); err != nil {
+		t.Fatal(err)
+	}
+	c := &Capture{Key: key, Status: CaptureStatusSuccess, Kinds: []string{kind}, Profiles: map[string]ProfileRef{kind: {Hash: hash}}}
+	if err := p.saveCapture(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestServeWebUI(t *testing.T) {
+	p := newTestPprof(t)
+	p.webUIs = newWebUIs(2)
+	newTestCapture(t, p, "c/ns/pod_1", "profile", testProfile(t))
+
+	serve := func(uiPath string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.ServeWebUI(w, httptest.NewRequest(http.MethodGet, "/ui/"+uiPath, nil), uiPath)
+		return w
+	}
+	if w := serve("c/ns/pod_1/profile"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/ui/c/ns/pod_1/profile/" {
+		t.Errorf("profile dir without slash: %d %s, want a redirect", w.Code, w.Header().Get("Location"))
+	}
+	w := serve("c/ns/pod_1/profile/top")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "main.work") {
+		t.Errorf("top view: %d, body contains main.work %v", w.Code, strings.Contains(w.Body.String(), "main.work"))
+	}
+	if w = serve("c/ns/pod_1/heap/top"); w.Code != http.StatusNotFound {
+		t.Errorf("missing kind: %d, want 404", w.Code)
+	}
+	if w = serve("c/ns/pod_1/profile/nothing"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown view: %d, want 404", w.Code)
+	}
+}
+
+func TestWebUISourceOutsidePaths(t *testing.T) {
+	p := newTestPprof(t)
+	p.webUIs = newWebUIs(2)
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret.go")
+	if err := os.WriteFile(secret, []byte(strings.Repeat("TOPSECRET\n", 25)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "src")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(strings.Repeat("INSIDE\n", 25)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	econf.Set("source.paths", []string{root})
+	econf.Set("source.trimPaths", []string{"/build"})
+	defer econf.Set("source.paths", []string{})
+	defer econf.Set("source.trimPaths", []string{})
+
+	sourceView := func(i int, name string) string {
+		c := newTestCapture(t, p, "c/ns/pod_"+strconv.Itoa(i), "profile", testProfileSource(t, name))
+		w := httptest.NewRecorder()
+		uiPath := c.Key + "/profile/source"
+		p.ServeWebUI(w, httptest.NewRequest(http.MethodGet, "/ui/"+uiPath+"?f=main.work", nil), uiPath)
+		if w.Code != http.StatusOK {
+			t.Fatalf("web ui source view of %s: %d %s", name, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+	if body := sourceView(0, "/build/main.go"); !strings.Contains(body, "INSIDE") {
+		t.Errorf("source view of /build/main.go did not show main.go of source.paths:\n%s", body)
+	}
+	for i, name := range []string{"/etc/passwd", secret, "../secret.go", "src/../../secret.go", "link.go", "etc/passwd"} {
+		if body := sourceView(i+1, name); strings.Contains(body, "TOPSECRET") || strings.Contains(body, ":x:") {
+			t.Errorf("source view of a profile naming %s read a file outside source.paths:\n%s", name, body)
+		}
+	}
+}
+
+func TestStorageFetcherMappings(t *testing.T) {
+	p := newTestPprof(t)
+	prof, err := profile.ParseData(testProfile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mapping names are paths in the target, the driver would open them on the goprobe host
+	prof.Mapping = []*profile.Mapping{
+		{ID: 1, Limit: 0x10000, File: "/etc/passwd", BuildID: "../.."},
+		{ID: 2, Start: 0x10000, Limit: 0x20000, File: "/usr/lib/libc.so.6", BuildID: "7d9f0a"},
+	}
+	for _, loc := range prof.Location {
+		loc.Mapping = prof.Mapping[0]
+	}
+	var raw bytes.Buffer
+	if err = prof.Write(&raw); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestCapture(t, p, "c/ns/pod_1", "profile", raw.Bytes())
+
+	fetched, _, err := (&storageFetcher{p: p, c: c, kind: "profile", sources: true}).Fetch(c.Key, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"", "7d9f0a"} {
+		if m := fetched.Mapping[i]; m.File != "" || m.BuildID != want {
+			t.Errorf("mapping %d = %s %s, want no file and build ID %q", m.ID, m.File, m.BuildID, want)
+		}
+	}
+	// symbolization passes the binary itself and reads the names
+	fetched, _, err = (&storageFetcher{p: p, c: c, kind: "profile"}).Fetch(c.Key, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := fetched.Mapping[0]; m.File != "/etc/passwd" {
+		t.Errorf("mapping file without sources = %s, want it kept", m.File)
+	}
+}
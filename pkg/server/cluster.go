@@ -0,0 +1,94 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"goprobe/pkg/dto"
+	"goprobe/pkg/kube"
+)
+
+func ListClusters(c *gin.Context) {
+	clusters, err := kube.ListClusters()
+	if err != nil {
+		JSONE(c, 1, "ListClusters: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, clusters)
+}
+
+// ClusterStatus 返回各集群最近一次健康检查结果
+func ClusterStatus(c *gin.Context) {
+	JSONOK(c, kube.ListClusterStatus())
+}
+
+func CreateCluster(c *gin.Context) {
+	var params dto.ReqCluster
+	err := c.ShouldBindJSON(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	err = kube.CreateCluster(toCluster(params))
+	if err != nil {
+		JSONE(c, 1, "CreateCluster: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, nil)
+}
+
+func UpdateCluster(c *gin.Context) {
+	var params dto.ReqCluster
+	err := c.ShouldBindJSON(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	err = kube.UpdateCluster(c.Param("name"), toCluster(params))
+	if err != nil {
+		JSONE(c, 1, "UpdateCluster: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, nil)
+}
+
+func DeleteCluster(c *gin.Context) {
+	err := kube.DeleteCluster(c.Param("name"))
+	if err != nil {
+		JSONE(c, 1, "DeleteCluster: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, nil)
+}
+
+// TestCluster checks the connectivity of a cluster without registering it.
+func TestCluster(c *gin.Context) {
+	var params dto.ReqCluster
+	err := c.ShouldBindJSON(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	version, err := kube.TestCluster(toCluster(params))
+	if err != nil {
+		JSONE(c, 1, "TestCluster: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, gin.H{"version": version})
+}
+
+func toCluster(params dto.ReqCluster) *kube.Cluster {
+	return &kube.Cluster{
+		Name:              params.Name,
+		Description:       params.Description,
+		ApiServer:         params.ApiServer,
+		Proxy:             params.Proxy,
+		QPS:               params.QPS,
+		Burst:             params.Burst,
+		KubeConfigContent: params.KubeConfig,
+		Context:           params.Context,
+		InCluster:         params.InCluster,
+		Token:             params.Token,
+		CAData:            params.CAData,
+		Source:            kube.ClusterSourceAPI,
+	}
+}
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"crypto/subtle"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -8,11 +12,18 @@ import (
 	"github.com/gotomicro/ego/core/elog"
 	"github.com/gotomicro/ego/core/etrace"
 	"github.com/gotomicro/ego/server/egin"
+	"k8s.io/apimachinery/pkg/labels"
 
 	"goprobe/pkg/dto"
+	"goprobe/pkg/kube"
 	"goprobe/pkg/pprof"
 )
 
+const (
+	defaultUploadMaxBytes = 64 << 20
+	defaultBinaryMaxBytes = 512 << 20
+)
+
 func ServeHTTP() *egin.Component {
 	router := egin.Load("server.http").Build()
 	router.GET("/api/pprof/run", func(ctx *gin.Context) {
@@ -22,10 +33,13 @@ func ServeHTTP() *egin.Component {
 			JSONE(ctx, 1, "参数无效: "+err.Error(), nil)
 			return
 		}
-		if params.Token != econf.GetString("token") {
+		identity, ok := tokenIdentity(params.Token)
+		if !ok {
 			JSONE(ctx, 1, "Token无效 ", nil)
 			return
 		}
+		ctx.Set(identityKey, identity)
+		params.Operator = operator(ctx, params.Operator)
 		list, err := pprof.Pprof.GeneratePprof(params)
 		if err != nil {
 			JSONE(ctx, 1, "生成pprof: "+err.Error(), nil)
@@ -35,9 +49,75 @@ func ServeHTTP() *egin.Component {
 	})
 	router.GET("/graph", Graph)
 	router.GET("/pprof-list", GetPprofList)
+	router.Any("/ui/*path", WebUI)
+	router.GET("/api/captures", ListHistory)
+	router.GET("/api/capture", GetCapture)
+	router.GET("/api/capture/raw", DownloadRaw)
+	router.GET("/api/capture/top", GetTop)
+	router.GET("/api/capture/export", Export)
+	router.GET("/api/capture/source", GetSource)
+	router.GET("/api/capture/bundle", DownloadBundle)
+
+	authed := router.Group("/api", TokenAuth())
+	authed.GET("/pods", ListPods)
+	authed.GET("/clusters", ListClusters)
+	authed.GET("/clusters/status", ClusterStatus)
+	authed.POST("/clusters", CreateCluster)
+	authed.POST("/clusters/test", TestCluster)
+	authed.PUT("/clusters/:name", UpdateCluster)
+	authed.DELETE("/clusters/:name", DeleteCluster)
+	authed.POST("/capture/pin", PinCapture)
+	authed.PUT("/capture", UpdateCapture)
+	authed.DELETE("/capture", DeleteCapture)
+	authed.POST("/captures/delete", DeleteCaptures)
+	authed.GET("/audit", ListAudit)
+	authed.POST("/capture/upload", UploadProfiles)
+	authed.POST("/capture/binary", AttachBinary)
+	authed.POST("/capture/symbolize", Symbolize)
+	authed.POST("/capture/rerender", Rerender)
 	return router
 }
 
+// identityKey 是 TokenAuth 存放认证身份的 gin context key
+const identityKey = "goprobe.identity"
+
+// sharedTokenIdentity 是共享 token 的身份, [tokens] 中的 token 以各自的用户名为身份
+const sharedTokenIdentity = "token"
+
+// tokenIdentity 返回 token 对应的身份: [tokens] 配置的 用户名 = token, 或共享 token
+func tokenIdentity(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	for name, t := range econf.GetStringMapString("tokens") {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return name, true
+		}
+	}
+	if t := econf.GetString("token"); t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+		return sharedTokenIdentity, true
+	}
+	return "", false
+}
+
+// TokenAuth 校验请求中的 token，支持 query/form 参数 token 或 header X-Goprobe-Token
+func TokenAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Goprobe-Token")
+		if token == "" {
+			token = c.Query("token")
+		}
+		identity, ok := tokenIdentity(token)
+		if !ok {
+			JSONE(c, 1, "Token无效 ", nil)
+			c.Abort()
+			return
+		}
+		c.Set(identityKey, identity)
+		c.Next()
+	}
+}
+
 func Graph(c *gin.Context) {
 	var params dto.ReqPprofGraph
 	err := c.Bind(&params)
@@ -51,7 +131,16 @@ func Graph(c *gin.Context) {
 		JSONE(c, 1, "FindGraphData: "+err.Error(), nil)
 		return
 	}
-	c.Data(http.StatusOK, "image/svg+xml", data)
+	switch {
+	case params.SvgType == "html":
+		c.Data(http.StatusOK, "text/html; charset=utf-8", data)
+	case params.Format == pprof.ImageFormatPng:
+		c.Data(http.StatusOK, "image/png", data)
+	case params.Format == pprof.ImageFormatPdf:
+		c.Data(http.StatusOK, "application/pdf", data)
+	default:
+		c.Data(http.StatusOK, "image/svg+xml", data)
+	}
 }
 
 func GetPprofList(c *gin.Context) {
@@ -69,6 +158,390 @@ func GetPprofList(c *gin.Context) {
 	JSONOK(c, data)
 }
 
+// WebUI 内嵌 go tool pprof -http 的交互页面, 路径为 /ui/<capture>/<kind>/<view>
+func WebUI(c *gin.Context) {
+	pprof.Pprof.ServeWebUI(c.Writer, c.Request, c.Param("path"))
+}
+
+// ListHistory 分页查询 capture 历史
+func ListHistory(c *gin.Context) {
+	var params dto.ReqListHistory
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, err := pprof.Pprof.ListHistory(params)
+	if err != nil {
+		JSONE(c, 1, "ListHistory: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, data)
+}
+
+func GetCapture(c *gin.Context) {
+	var params dto.ReqGetCapture
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, err := pprof.Pprof.GetCapture(params.Url)
+	if err != nil {
+		JSONE(c, 1, "GetCapture: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, data)
+}
+
+// GetTop 返回 profile 的 top 表和汇总信息
+func GetTop(c *gin.Context) {
+	var params dto.ReqGetTop
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, err := pprof.Pprof.GetTop(params)
+	if err != nil {
+		JSONE(c, 1, "GetTop: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, data)
+}
+
+// Export 将 profile 导出为 speedscope、折叠栈或 Chrome trace 格式
+func Export(c *gin.Context) {
+	var params dto.ReqExport
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, filename, err := pprof.Pprof.Export(params)
+	if err != nil {
+		JSONE(c, 1, "Export: "+err.Error(), nil)
+		return
+	}
+	contentType := "application/json"
+	if params.Format == pprof.ExportFormatCollapsed {
+		contentType = "text/plain; charset=utf-8"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// GetSource 返回函数按源码行或汇编指令标注的采样, 同 go tool pprof -list/-disasm
+func GetSource(c *gin.Context) {
+	var params dto.ReqGetSource
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, err := pprof.Pprof.GetSource(params)
+	if err != nil {
+		JSONE(c, 1, "GetSource: "+err.Error(), nil)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", data)
+}
+
+// DownloadRaw 下载原始 profile, 可直接用 go tool pprof 打开
+func DownloadRaw(c *gin.Context) {
+	var params dto.ReqDownloadRaw
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	r, filename, err := pprof.Pprof.GetRawProfile(params.Url, params.GoType)
+	if err != nil {
+		JSONE(c, 1, "GetRawProfile: "+err.Error(), nil)
+		return
+	}
+	defer r.Close()
+	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", r, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": filename}),
+	})
+}
+
+// DownloadBundle 打包下载 capture 的全部原始 profile、图和元数据
+func DownloadBundle(c *gin.Context) {
+	var params dto.ReqDownloadBundle
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	if params.Format == "" {
+		params.Format = pprof.BundleFormatZip
+	}
+	filename, err := pprof.Pprof.BundleFilename(params.Url, params.Format)
+	if err != nil {
+		JSONE(c, 1, "DownloadBundle: "+err.Error(), nil)
+		return
+	}
+	contentType := "application/zip"
+	if params.Format == pprof.BundleFormatTarGz {
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+	err = pprof.Pprof.WriteBundle(c.Writer, params.Url, params.Format)
+	if err != nil {
+		// headers are already sent, the client sees a truncated archive
+		elog.Error("write bundle error", elog.FieldErr(err), elog.FieldTid(etrace.ExtractTraceID(c.Request.Context())))
+	}
+}
+
+// UploadProfiles 上传外部采集的 profile, 校验后按 capture 存储并渲染
+func UploadProfiles(c *gin.Context) {
+	maxBytes := econf.GetInt64("upload.maxBytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultUploadMaxBytes
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	var params dto.ReqUploadProfile
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	var files []pprof.UploadFile
+	for kind, headers := range form.File {
+		for _, header := range headers {
+			data, err := readFormFile(header)
+			if err != nil {
+				JSONE(c, 1, "读取上传文件失败: "+err.Error(), nil)
+				return
+			}
+			files = append(files, pprof.UploadFile{Kind: kind, Data: data})
+		}
+	}
+	params.Operator = operator(c, params.Operator)
+	list, err := pprof.Pprof.UploadProfiles(params, files)
+	if err != nil {
+		JSONE(c, 1, "UploadProfiles: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, list)
+}
+
+// AttachBinary 上传 capture 目标进程的可执行文件, 用于 disasm 和符号化
+func AttachBinary(c *gin.Context) {
+	maxBytes := econf.GetInt64("upload.maxBinaryBytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultBinaryMaxBytes
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	var params dto.ReqAttachBinary
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	header, err := c.FormFile("binary")
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		JSONE(c, 1, "读取上传文件失败: "+err.Error(), nil)
+		return
+	}
+	defer f.Close()
+	data, err := pprof.Pprof.AttachBinary(params.Url, header.Filename, f, operator(c, params.Operator))
+	if err != nil {
+		JSONE(c, 1, "AttachBinary: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, data)
+}
+
+// Symbolize 用上传的或按 build ID 在 symbolize.binaryDir 中找到的二进制重新符号化 profile
+func Symbolize(c *gin.Context) {
+	var params dto.ReqSymbolize
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, err := pprof.Pprof.Symbolize(params, operator(c, params.Operator))
+	if err != nil {
+		JSONE(c, 1, "Symbolize: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, data)
+}
+
+// Rerender 删除 capture 缓存的图, 在下次请求时重新渲染
+func Rerender(c *gin.Context) {
+	var params dto.ReqRerender
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	rerendered, err := pprof.Pprof.Rerender(params, operator(c, params.Operator))
+	if err != nil {
+		JSONE(c, 1, "Rerender: "+err.Error(), rerendered)
+		return
+	}
+	JSONOK(c, rerendered)
+}
+
+func readFormFile(header *multipart.FileHeader) ([]byte, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// UpdateCapture 修改 capture 的标签和备注
+func UpdateCapture(c *gin.Context) {
+	var params dto.ReqUpdateCapture
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, err := pprof.Pprof.UpdateCapture(params, operator(c, params.Operator))
+	if err != nil {
+		JSONE(c, 1, "UpdateCapture: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, data)
+}
+
+// PinCapture 固定/取消固定 capture, 固定后不会被保留策略清理
+func PinCapture(c *gin.Context) {
+	var params dto.ReqPinCapture
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, err := pprof.Pprof.PinCapture(params.Url, params.Pinned)
+	if err != nil {
+		JSONE(c, 1, "PinCapture: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, data)
+}
+
+// DeleteCapture 删除单个 capture 的全部文件
+func DeleteCapture(c *gin.Context) {
+	var params dto.ReqDeleteCapture
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	err = pprof.Pprof.DeleteCapture(params.Url, operator(c, params.Operator))
+	if err != nil {
+		JSONE(c, 1, "DeleteCapture: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, nil)
+}
+
+// DeleteCaptures 按条件批量删除 capture, 返回已删除的 url
+func DeleteCaptures(c *gin.Context) {
+	var params dto.ReqDeleteCaptures
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	deleted, err := pprof.Pprof.DeleteCaptures(params, operator(c, params.Operator))
+	if err != nil {
+		JSONE(c, 1, "DeleteCaptures: "+err.Error(), deleted)
+		return
+	}
+	JSONOK(c, deleted)
+}
+
+func ListAudit(c *gin.Context) {
+	var params dto.ReqListAudit
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	data, err := pprof.Pprof.ListAudit(params.Date)
+	if err != nil {
+		JSONE(c, 1, "ListAudit: "+err.Error(), nil)
+		return
+	}
+	JSONOK(c, data)
+}
+
+// operator 返回操作人: TokenAuth 认证的身份, 客户端声明的操作人 (参数 > header X-Goprobe-Operator)
+// 只作为备注附在后面, 不能冒充其他身份. 未经认证时为客户端 IP
+func operator(c *gin.Context, param string) string {
+	claimed := param
+	if claimed == "" {
+		claimed = c.GetHeader("X-Goprobe-Operator")
+	}
+	identity := c.GetString(identityKey)
+	if identity == "" {
+		identity = c.ClientIP()
+	}
+	if claimed == "" || claimed == identity {
+		return identity
+	}
+	return identity + " (" + claimed + ")"
+}
+
+func ListPods(c *gin.Context) {
+	var params dto.ReqListPods
+	err := c.Bind(&params)
+	if err != nil {
+		JSONE(c, 1, "参数无效: "+err.Error(), nil)
+		return
+	}
+	selector, err := labels.Parse(params.LabelSelector)
+	if err != nil {
+		JSONE(c, 1, "labelSelector无效: "+err.Error(), nil)
+		return
+	}
+	manager, err := kube.GetClusterManager(params.ClusterName)
+	if err != nil {
+		JSONE(c, 1, "GetClusterManager: "+err.Error(), nil)
+		return
+	}
+	defer manager.Release()
+	pods, err := manager.ListPods(params.Namespace, selector)
+	if err != nil {
+		JSONE(c, 1, "ListPods: "+err.Error(), nil)
+		return
+	}
+	list := make([]dto.RespPodItem, 0, len(pods))
+	for _, pod := range pods {
+		workload := manager.GetPodWorkload(pod)
+		list = append(list, dto.RespPodItem{
+			Name:         pod.Name,
+			Namespace:    pod.Namespace,
+			PodIP:        pod.Status.PodIP,
+			NodeName:     pod.Spec.NodeName,
+			Phase:        string(pod.Status.Phase),
+			WorkloadKind: workload.Kind,
+			WorkloadName: workload.Name,
+		})
+	}
+	JSONOK(c, list)
+}
+
 // JSONE 输出失败响应
 // 形如 {"code":<code>, "msg":<msg>, "data":<data>}
 func JSONE(c *gin.Context, code int, msg string, data interface{}) {
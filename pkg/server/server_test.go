@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gotomicro/ego/core/econf"
+)
+
+func TestOperator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	econf.Set("token", "shared")
+	econf.Set("tokens", map[string]interface{}{"alice": "alice-token"})
+	defer econf.Set("token", "")
+	defer econf.Set("tokens", map[string]interface{}{})
+
+	router := gin.New()
+	router.GET("/op", TokenAuth(), func(c *gin.Context) {
+		c.String(http.StatusOK, operator(c, c.Query("operator")))
+	})
+	cases := []struct {
+		token, header, query string
+		want                 string
+	}{
+		{token: "alice-token", want: "alice"},
+		{token: "alice-token", header: "alice", want: "alice"},
+		{token: "alice-token", header: "bob", want: "alice (bob)"},
+		{token: "alice-token", query: "bob", header: "carol", want: "alice (bob)"},
+		{token: "shared", header: "bob", want: "token (bob)"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/op?operator="+tc.query, nil)
+		req.Header.Set("X-Goprobe-Token", tc.token)
+		if tc.header != "" {
+			req.Header.Set("X-Goprobe-Operator", tc.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if got := w.Body.String(); got != tc.want {
+			t.Errorf("operator with token %s, header %q, param %q = %q, want %q", tc.token, tc.header, tc.query, got, tc.want)
+		}
+	}
+
+	for _, token := range []string{"", "bob", "alice"} {
+		req := httptest.NewRequest(http.MethodGet, "/op", nil)
+		req.Header.Set("X-Goprobe-Token", token)
+		req.Header.Set("X-Goprobe-Operator", "alice")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if got := w.Body.String(); got == "alice" {
+			t.Errorf("token %q authenticated as alice", token)
+		}
+	}
+}
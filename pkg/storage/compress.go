@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// gzipMagic starts every gzip stream, objects stored before compression was enabled lack it.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// DefaultCompressSuffixes are text artifacts worth compressing, raw profiles are gzipped already.
+var DefaultCompressSuffixes = []string{".svg", ".json", ".html"}
+
+// compressed gzips objects whose key ends with one of suffixes transparently, keys are unchanged.
+type compressed struct {
+	Client
+	suffixes []string
+}
+
+// NewCompressed wraps c so objects matching suffixes are stored gzipped and decompressed on read.
+// Uncompressed objects written before are still read as is.
+func NewCompressed(c Client, suffixes []string) Client {
+	return &compressed{Client: c, suffixes: suffixes}
+}
+
+func (c *compressed) match(key string) bool {
+	for _, suffix := range c.suffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compressed) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	r, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (c *compressed) PutBytes(ctx context.Context, key string, data []byte) error {
+	return c.Put(ctx, key, bytes.NewReader(data))
+}
+
+func (c *compressed) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := c.Client.Get(ctx, key)
+	if err != nil || !c.match(key) {
+		return r, err
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return &readCloser{Reader: br, close: r.Close}, nil
+	}
+	gr, err := gzip.NewReader(br)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: gr, close: func() error {
+		gr.Close()
+		return r.Close()
+	}}, nil
+}
+
+func (c *compressed) Put(ctx context.Context, key string, r io.Reader) error {
+	if !c.match(key) {
+		return c.Client.Put(ctx, key, r)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		gw := gzip.NewWriter(pw)
+		_, err := io.Copy(gw, r)
+		if closeErr := gw.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	err := c.Client.Put(ctx, key, pr)
+	// stop the compressing goroutine if Put returned before reading everything
+	pr.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
@@ -0,0 +1,43 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"goprobe/pkg/storage"
+	"goprobe/pkg/storage/filesystem"
+)
+
+func TestCompressed(t *testing.T) {
+	ctx := context.Background()
+	raw := filesystem.NewFilesystemClient(t.TempDir())
+	c := storage.NewCompressed(raw, storage.DefaultCompressSuffixes)
+	svg := bytes.Repeat([]byte("<svg></svg>"), 100)
+
+	if err := c.PutBytes(ctx, "k/heap_flame.svg", svg); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := raw.GetBytes(ctx, "k/heap_flame.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) >= len(svg) {
+		t.Errorf("svg stored with %d bytes, want compressed below %d", len(stored), len(svg))
+	}
+	data, err := c.GetBytes(ctx, "k/heap_flame.svg")
+	if err != nil || !bytes.Equal(data, svg) {
+		t.Errorf("GetBytes() = %d bytes, %v, want the original svg", len(data), err)
+	}
+
+	// objects written before compression was enabled and other suffixes are stored as is
+	for _, key := range []string{"k/legacy.svg", "k/heap.bin"} {
+		if err = raw.PutBytes(ctx, key, []byte("plain")); err != nil {
+			t.Fatal(err)
+		}
+		data, err = c.GetBytes(ctx, key)
+		if err != nil || string(data) != "plain" {
+			t.Errorf("GetBytes(%s) = %q, %v, want plain", key, data, err)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package filesystem
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -11,6 +13,9 @@ import (
 	"goprobe/pkg/storage"
 )
 
+// tmpPrefix marks files being written, they are renamed over their key once complete.
+const tmpPrefix = ".tmp-"
+
 type Client struct {
 	basePath string
 }
@@ -21,45 +26,169 @@ func NewFilesystemClient(basePath string) *Client {
 
 var _ storage.Client = &Client{}
 
+// path maps a slash separated key to a file below basePath, keys escaping basePath with .. are refused.
+func (c Client) path(key string) (string, error) {
+	name := filepath.Join(c.basePath, filepath.FromSlash(key))
+	rel, err := filepath.Rel(c.basePath, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %s is outside the storage root", key)
+	}
+	return name, nil
+}
+
 func (c Client) GetBytes(ctx context.Context, key string) ([]byte, error) {
-	f, err := os.Open(filepath.Join(c.basePath, key))
+	f, err := c.Get(ctx, key)
 	if err != nil {
-		return nil, fmt.Errorf("open file error: %w", err)
+		return nil, err
 	}
 	defer f.Close()
 	return ioutil.ReadAll(f)
 }
 
 func (c Client) PutBytes(ctx context.Context, key string, data []byte) error {
-	path := key[:strings.LastIndex(key, string(filepath.Separator))]
-	err := os.MkdirAll(filepath.Join(c.basePath, path), 0755)
+	return c.Put(ctx, key, bytes.NewReader(data))
+}
+
+func (c Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := c.path(key)
 	if err != nil {
-		return fmt.Errorf("mkdir error: %w", err)
+		return nil, err
 	}
-	f, err := os.OpenFile(filepath.Join(c.basePath, key), os.O_WRONLY|os.O_CREATE, 0755)
+	f, err := os.Open(name)
 	if err != nil {
-		return fmt.Errorf("open file error: %w", err)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("open file error: %s: %w", key, storage.ErrNotFound)
+		}
+		return nil, fmt.Errorf("open file error: %w", err)
 	}
-	defer f.Close()
-	_, err = f.Write(data)
+	return f, nil
+}
+
+// Put writes to a temp file in the target directory and renames it over key.
+func (c Client) Put(ctx context.Context, key string, r io.Reader) error {
+	if strings.Trim(key, "/") == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	path, err := c.path(key)
 	if err != nil {
 		return err
 	}
-	return nil
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir error: %w", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), tmpPrefix)
+	if err != nil {
+		return fmt.Errorf("create temp file error: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write file error: %s: %w", key, err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync file error: %s: %w", key, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (c Client) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	name, err := c.path(key)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return storage.ObjectInfo{}, fmt.Errorf("stat file error: %s: %w", key, storage.ErrNotFound)
+		}
+		return storage.ObjectInfo{}, fmt.Errorf("stat file error: %w", err)
+	}
+	if fi.IsDir() {
+		// only objects exist in object storages, keep the backends consistent
+		return storage.ObjectInfo{}, fmt.Errorf("stat file error: %s is a directory: %w", key, storage.ErrNotFound)
+	}
+	return storage.ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
 }
 
 func (c Client) List(ctx context.Context, key string) ([]string, error) {
-	ps, err := os.ReadDir(filepath.Join(c.basePath, key))
+	name, err := c.path(key)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := os.ReadDir(name)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("read dir error: %s: %w", key, storage.ErrNotFound)
+		}
 		return nil, err
 	}
 	var out []string
 	for _, p := range ps {
+		if strings.HasPrefix(p.Name(), tmpPrefix) {
+			continue
+		}
 		out = append(out, p.Name())
 	}
 	return out, nil
 }
 
+func (c Client) ListObjects(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	root, err := c.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var out []storage.ObjectInfo
+	err = filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// missing prefix, or a file removed during the walk
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), tmpPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(c.basePath, name)
+		if err != nil {
+			return err
+		}
+		out = append(out, storage.ObjectInfo{Key: filepath.ToSlash(rel), Size: fi.Size(), ModTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk dir error: %s: %w", prefix, err)
+	}
+	return out, nil
+}
+
 func (c Client) Delete(ctx context.Context, key string) error {
-	return os.Remove(filepath.Join(c.basePath, key))
+	name, err := c.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("remove error: %s: %w", key, storage.ErrNotFound)
+	}
+	return err
+}
+
+func (c Client) DeletePrefix(ctx context.Context, prefix string) error {
+	name, err := c.path(prefix)
+	if err != nil {
+		return err
+	}
+	if filepath.Clean(name) == filepath.Clean(c.basePath) {
+		return fmt.Errorf("refuse to delete the storage root")
+	}
+	return os.RemoveAll(name)
 }
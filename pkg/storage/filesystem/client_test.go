@@ -0,0 +1,144 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"goprobe/pkg/storage"
+)
+
+func TestClientNotFound(t *testing.T) {
+	c := NewFilesystemClient(t.TempDir())
+	ctx := context.Background()
+	if _, err := c.GetBytes(ctx, "a/b.bin"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetBytes() error = %v, want ErrNotFound", err)
+	}
+	if _, err := c.List(ctx, "a"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("List() error = %v, want ErrNotFound", err)
+	}
+	if err := c.Delete(ctx, "a/b.bin"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClientDeletePrefix(t *testing.T) {
+	c := NewFilesystemClient(t.TempDir())
+	ctx := context.Background()
+	for _, key := range []string{"c/ns/pod_1/heap.bin", "c/ns/pod_1/heap_flame.svg", "c/ns/pod_2/heap.bin"} {
+		if err := c.PutBytes(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.DeletePrefix(ctx, "c/ns/pod_1"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := c.List(ctx, "c/ns")
+	if err != nil || len(names) != 1 || names[0] != "pod_2" {
+		t.Fatalf("List() = %v, %v", names, err)
+	}
+	if err = c.DeletePrefix(ctx, "c/ns/pod_1"); err != nil {
+		t.Errorf("DeletePrefix() of a missing prefix error = %v", err)
+	}
+	if err = c.DeletePrefix(ctx, "/"); err == nil {
+		t.Error("DeletePrefix() of the root succeeded")
+	}
+}
+
+func TestClientListObjects(t *testing.T) {
+	c := NewFilesystemClient(t.TempDir())
+	ctx := context.Background()
+	if objects, err := c.ListObjects(ctx, "c"); err != nil || len(objects) != 0 {
+		t.Fatalf("ListObjects() of a missing prefix = %v, %v", objects, err)
+	}
+	for _, key := range []string{"c/ns/pod_1/heap.bin", "c/ns/pod_1/meta.json", "d/ns/pod_2/heap.bin"} {
+		if err := c.PutBytes(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.basePath, "c", "ns", tmpPrefix+"1"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := c.ListObjects(ctx, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Key != "c/ns/pod_1/heap.bin" || objects[1].Key != "c/ns/pod_1/meta.json" {
+		t.Fatalf("ListObjects() = %+v, want the two objects of c/ns/pod_1", objects)
+	}
+	if objects[1].Size != int64(len("c/ns/pod_1/meta.json")) || objects[1].ModTime.IsZero() {
+		t.Errorf("ListObjects() info = %+v", objects[1])
+	}
+}
+
+func TestClientPut(t *testing.T) {
+	c := NewFilesystemClient(t.TempDir())
+	ctx := context.Background()
+	// top level keys have no directory
+	if err := c.PutBytes(ctx, "top.json", []byte("a longer content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutBytes(ctx, "top.json", []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.GetBytes(ctx, "top.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "short" {
+		t.Errorf("GetBytes() = %q after overwrite, want %q", data, "short")
+	}
+	info, err := c.Stat(ctx, "top.json")
+	if err != nil || info.Size != 5 {
+		t.Errorf("Stat() = %+v, %v, want size 5", info, err)
+	}
+	if ok, err := storage.Exists(ctx, c, "missing.json"); ok || err != nil {
+		t.Errorf("Exists() = %v, %v for a missing key", ok, err)
+	}
+
+	w := storage.NewWriter(ctx, c, "a/b/stream.bin")
+	if _, err = w.Write([]byte("streamed")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, err := c.List(ctx, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "stream.bin" {
+		t.Errorf("List() = %v, want only stream.bin", names)
+	}
+}
+
+func TestClientOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret.bin"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFilesystemClient(filepath.Join(dir, "root"))
+	ctx := context.Background()
+	for _, key := range []string{"../secret.bin", "a/../../secret.bin", "/../secret.bin"} {
+		if _, err := c.GetBytes(ctx, key); err == nil {
+			t.Errorf("GetBytes(%s) read outside the root", key)
+		}
+		if _, err := c.Stat(ctx, key); err == nil {
+			t.Errorf("Stat(%s) succeeded outside the root", key)
+		}
+		if err := c.PutBytes(ctx, key, []byte("x")); err == nil {
+			t.Errorf("PutBytes(%s) wrote outside the root", key)
+		}
+		if err := c.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%s) removed a file outside the root", key)
+		}
+	}
+	if err := c.DeletePrefix(ctx, "a/.."); err == nil {
+		t.Error("DeletePrefix() of the root succeeded")
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "secret.bin")); err != nil || string(data) != "secret" {
+		t.Errorf("secret.bin = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,254 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"goprobe/pkg/storage"
+)
+
+// Config of an S3 compatible object storage, e.g. AWS S3 or MinIO.
+type Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	// Prefix is prepended to every key so several deployments can share a bucket.
+	Prefix string
+	UseSSL bool
+}
+
+type Client struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+var _ storage.Client = &Client{}
+
+// NewS3Client connects to the object storage and creates the bucket if it doesn't exist.
+func NewS3Client(config Config) (*Client, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket cannot be empty")
+	}
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("new s3 client error: %w", err)
+	}
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("check s3 bucket error: %w", err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region})
+		if err != nil {
+			return nil, fmt.Errorf("make s3 bucket error: %w", err)
+		}
+	}
+	return &Client{
+		client: client,
+		bucket: config.Bucket,
+		prefix: strings.Trim(config.Prefix, "/"),
+	}, nil
+}
+
+// objectName maps a slash separated key to an object below prefix, keys escaping prefix with .. are refused.
+func (c *Client) objectName(key string) (string, error) {
+	name := path.Join(c.prefix, key)
+	if c.prefix == "" && (name == ".." || strings.HasPrefix(name, "../")) ||
+		c.prefix != "" && name != c.prefix && !strings.HasPrefix(name, c.prefix+"/") {
+		return "", fmt.Errorf("key %s is outside the storage root", key)
+	}
+	return name, nil
+}
+
+func (c *Client) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	obj, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, wrapErr("read object error", key, err)
+	}
+	return data, nil
+}
+
+func (c *Client) PutBytes(ctx context.Context, key string, data []byte) error {
+	name, err := c.objectName(key)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PutObject(ctx, c.bucket, name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	if err != nil {
+		return wrapErr("put object error", key, err)
+	}
+	return nil
+}
+
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := c.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := c.client.GetObject(ctx, c.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, wrapErr("get object error", key, err)
+	}
+	// GetObject is lazy, stat to report missing keys here rather than on the first Read
+	if _, err = obj.Stat(); err != nil {
+		obj.Close()
+		return nil, wrapErr("get object error", key, err)
+	}
+	return obj, nil
+}
+
+// Put streams r as a multipart upload, S3 only makes an object visible once the upload completes.
+func (c *Client) Put(ctx context.Context, key string, r io.Reader) error {
+	name, err := c.objectName(key)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PutObject(ctx, c.bucket, name, r, -1, minio.PutObjectOptions{})
+	if err != nil {
+		return wrapErr("put object error", key, err)
+	}
+	return nil
+}
+
+func (c *Client) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	name, err := c.objectName(key)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	info, err := c.client.StatObject(ctx, c.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return storage.ObjectInfo{}, wrapErr("stat object error", key, err)
+	}
+	return storage.ObjectInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+// List returns the direct children of key, "directories" being common prefixes.
+func (c *Client) List(ctx context.Context, key string) ([]string, error) {
+	name, err := c.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+	var out []string
+	for obj := range c.client.ListObjects(ctx, c.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, wrapErr("list objects error", key, obj.Err)
+		}
+		out = append(out, strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), "/"))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("list objects error: %s: %w", key, storage.ErrNotFound)
+	}
+	return out, nil
+}
+
+func (c *Client) ListObjects(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	name, err := c.objectName(prefix)
+	if err != nil {
+		return nil, err
+	}
+	listPrefix := strings.TrimSuffix(name, "/") + "/"
+	if listPrefix == "/" {
+		listPrefix = ""
+	}
+	root := ""
+	if c.prefix != "" {
+		root = c.prefix + "/"
+	}
+	var out []storage.ObjectInfo
+	opts := minio.ListObjectsOptions{Prefix: listPrefix, Recursive: true}
+	for obj := range c.client.ListObjects(ctx, c.bucket, opts) {
+		if obj.Err != nil {
+			return nil, wrapErr("list objects error", prefix, obj.Err)
+		}
+		out = append(out, storage.ObjectInfo{Key: strings.TrimPrefix(obj.Key, root), Size: obj.Size, ModTime: obj.LastModified})
+	}
+	return out, nil
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	name, err := c.objectName(key)
+	if err != nil {
+		return err
+	}
+	// RemoveObject succeeds on missing objects, stat first to report not found like the filesystem does
+	_, err = c.client.StatObject(ctx, c.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return wrapErr("stat object error", key, err)
+	}
+	err = c.client.RemoveObject(ctx, c.bucket, name, minio.RemoveObjectOptions{})
+	if err != nil {
+		return wrapErr("remove object error", key, err)
+	}
+	return nil
+}
+
+func (c *Client) DeletePrefix(ctx context.Context, prefix string) error {
+	name, err := c.objectName(prefix)
+	if err != nil {
+		return err
+	}
+	if name == c.prefix || strings.Trim(name, "/.") == "" {
+		return fmt.Errorf("refuse to delete the storage root")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	objects := make(chan minio.ObjectInfo)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(objects)
+		opts := minio.ListObjectsOptions{Prefix: strings.TrimSuffix(name, "/") + "/", Recursive: true}
+		for obj := range c.client.ListObjects(ctx, c.bucket, opts) {
+			if obj.Err != nil {
+				listErr <- obj.Err
+				return
+			}
+			select {
+			case objects <- obj:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for removeErr := range c.client.RemoveObjects(ctx, c.bucket, objects, minio.RemoveObjectsOptions{}) {
+		return wrapErr("remove objects error", removeErr.ObjectName, removeErr.Err)
+	}
+	select {
+	case err := <-listErr:
+		return wrapErr("list objects error", prefix, err)
+	default:
+	}
+	return nil
+}
+
+func wrapErr(msg, key string, err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return fmt.Errorf("%s: %s: %w", msg, key, storage.ErrNotFound)
+	}
+	return fmt.Errorf("%s: %s: %w", msg, key, err)
+}
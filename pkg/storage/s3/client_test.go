@@ -0,0 +1,107 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"goprobe/pkg/storage"
+)
+
+// Run against a local MinIO:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	GOPROBE_S3_ENDPOINT=127.0.0.1:9000 go test ./pkg/storage/s3/
+func newTestClient(t *testing.T) *Client {
+	endpoint := os.Getenv("GOPROBE_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("GOPROBE_S3_ENDPOINT not set")
+	}
+	accessKey, secretKey := os.Getenv("GOPROBE_S3_ACCESS_KEY"), os.Getenv("GOPROBE_S3_SECRET_KEY")
+	if accessKey == "" {
+		accessKey, secretKey = "minioadmin", "minioadmin"
+	}
+	c, err := NewS3Client(Config{
+		Endpoint:  endpoint,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Bucket:    "goprobe-test",
+		Prefix:    "test-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := c.GetBytes(ctx, "c/ns/pod_1/heap.bin"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetBytes() error = %v, want ErrNotFound", err)
+	}
+	for _, key := range []string{"c/ns/pod_1/heap.bin", "c/ns/pod_1/heap_flame.svg", "c/ns/pod_2/heap.bin"} {
+		if err := c.PutBytes(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := c.GetBytes(ctx, "c/ns/pod_1/heap.bin")
+	if err != nil || string(data) != "c/ns/pod_1/heap.bin" {
+		t.Fatalf("GetBytes() = %s, %v", data, err)
+	}
+	names, err := c.List(ctx, "c/ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "pod_1" || names[1] != "pod_2" {
+		t.Fatalf("List() = %v", names)
+	}
+	objects, err := c.ListObjects(ctx, "c/ns/pod_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Key != "c/ns/pod_1/heap.bin" || objects[1].Key != "c/ns/pod_1/heap_flame.svg" {
+		t.Fatalf("ListObjects() = %+v", objects)
+	}
+	if err = c.Delete(ctx, "c/ns/pod_2/heap.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete(ctx, "c/ns/pod_2/heap.bin"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Delete() error = %v, want ErrNotFound", err)
+	}
+	if err = c.DeletePrefix(ctx, "c/ns/pod_1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.List(ctx, "c/ns"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("List() after DeletePrefix error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestObjectNameOutsideRoot(t *testing.T) {
+	for _, prefix := range []string{"", "pprof"} {
+		c := &Client{prefix: prefix}
+		keys := []string{"../secret.bin", "a/../../secret.bin", ".."}
+		if prefix != "" {
+			// /.. is the bucket root without a prefix
+			keys = append(keys, "/../secret.bin")
+		}
+		for _, key := range keys {
+			if name, err := c.objectName(key); err == nil {
+				t.Errorf("objectName(%s) with prefix %q = %s, want an error", key, prefix, name)
+			}
+		}
+		if name, err := c.objectName("a/../c/ns/pod_1/heap.bin"); err != nil || name != path.Join(prefix, "c/ns/pod_1/heap.bin") {
+			t.Errorf("objectName() with prefix %q = %s, %v", prefix, name, err)
+		}
+		if err := c.DeletePrefix(context.Background(), "a/.."); err == nil {
+			t.Errorf("DeletePrefix() of the root with prefix %q succeeded", prefix)
+		}
+	}
+}
@@ -2,11 +2,87 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"io"
+	"time"
 )
 
+// ErrNotFound is returned, possibly wrapped, when a key doesn't exist.
+var ErrNotFound = errors.New("storage: key not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key     string
+	Size    int64 // bytes in the backend, compressed objects report their compressed size
+	ModTime time.Time
+}
+
 type Client interface {
 	GetBytes(ctx context.Context, key string) ([]byte, error)
 	PutBytes(ctx context.Context, key string, data []byte) error
+	// Get opens key for reading, the caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put stores everything read from r under key. Readers see either the previous or the new content, never a partial write.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Stat returns the info of object key, ErrNotFound if it doesn't exist.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
 	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes key and everything below it, a missing prefix is not an error.
+	DeletePrefix(ctx context.Context, prefix string) error
+	// List returns the names of the direct children of key.
 	List(ctx context.Context, key string) ([]string, error)
+	// ListObjects returns the info of every object below prefix recursively, keys are relative to the storage root.
+	// A missing prefix returns no objects.
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// Exists reports whether object key exists.
+func Exists(ctx context.Context, c Client, key string) (bool, error) {
+	_, err := c.Stat(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewWriter returns a writer streaming into key, the object is stored once Close returns nil.
+// The writer must always be closed, closing it after a failed Write leaves key untouched.
+func NewWriter(ctx context.Context, c Client, key string) io.WriteCloser {
+	pr, pw := io.Pipe()
+	w := &writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := c.Put(ctx, key, pr)
+		// unblock the writer if Put stopped reading early
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+type writer struct {
+	pw   *io.PipeWriter
+	done chan error
+	err  error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.pw.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *writer) Close() error {
+	if w.err != nil {
+		// abort the Put so the partial content is discarded
+		w.pw.CloseWithError(w.err)
+		<-w.done
+		return w.err
+	}
+	w.pw.Close()
+	return <-w.done
 }